@@ -0,0 +1,75 @@
+// Package auth authenticates clients during the Option handshake and
+// carries the authenticated principal to the handlers of their requests.
+package auth
+
+import (
+	"context"
+	"errors"
+	"vrpc/codec"
+)
+
+// Principal identifies an authenticated client.
+type Principal struct {
+	Name  string
+	Roles []string
+}
+
+// HasRole reports whether p has role.
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator checks the credentials a client sent in the handshake.
+// It returns the principal attached to every request of the connection, or
+// an error to reject the connection. creds is nil if the client sent none.
+// ctx carries the peer of the connection, see server.PeerFromContext.
+type Authenticator interface {
+	Authenticate(ctx context.Context, creds *codec.Credentials) (*Principal, error)
+}
+
+// AuthenticatorFunc is an adapter to allow the use of ordinary functions as Authenticator.
+type AuthenticatorFunc func(ctx context.Context, creds *codec.Credentials) (*Principal, error)
+
+// Authenticate calls f(ctx, creds).
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, creds *codec.Credentials) (*Principal, error) {
+	return f(ctx, creds)
+}
+
+var (
+	ErrMissingCredentials = errors.New("auth: missing credentials")
+	ErrUnsupportedScheme  = errors.New("auth: unsupported scheme")
+	ErrInvalidCredentials = errors.New("auth: invalid credentials")
+)
+
+// Chain returns an Authenticator trying each of authenticators in order,
+// the first one that doesn't fail with ErrUnsupportedScheme decides.
+func Chain(authenticators ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, creds *codec.Credentials) (*Principal, error) {
+		for _, a := range authenticators {
+			p, err := a.Authenticate(ctx, creds)
+			if err != ErrUnsupportedScheme {
+				return p, err
+			}
+		}
+		return nil, ErrUnsupportedScheme
+	})
+}
+
+type principalKey struct{}
+
+// NewContext returns a context carrying p.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of the call handled with ctx, if the
+// connection was authenticated.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
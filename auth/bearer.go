@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"vrpc/codec"
+)
+
+// SchemeBearer sends a static token in Values["token"].
+const SchemeBearer = "bearer"
+
+// BearerCredentials returns the credentials presenting token.
+func BearerCredentials(token string) *codec.Credentials {
+	return &codec.Credentials{Scheme: SchemeBearer, Values: map[string]string{"token": token}}
+}
+
+// Bearer returns an Authenticator accepting the tokens in tokens, each
+// mapped to the principal it authenticates.
+func Bearer(tokens map[string]*Principal) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, creds *codec.Credentials) (*Principal, error) {
+		if creds == nil {
+			return nil, ErrMissingCredentials
+		}
+		if creds.Scheme != SchemeBearer {
+			return nil, ErrUnsupportedScheme
+		}
+		token := creds.Values["token"]
+		for t, p := range tokens {
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+				return p, nil
+			}
+		}
+		return nil, ErrInvalidCredentials
+	})
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+	"vrpc/codec"
+)
+
+// SchemeHMAC signs a fresh nonce and the current time with a shared secret.
+// Values carries "key", "nonce", "timestamp" (unix seconds) and "signature",
+// the hex encoded HMAC-SHA256 of "key\nnonce\ntimestamp".
+const SchemeHMAC = "hmac"
+
+// HMACKey is a shared secret and the principal it authenticates.
+type HMACKey struct {
+	Secret    []byte
+	Principal *Principal
+}
+
+func sign(secret []byte, key, nonce, timestamp string) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte(key + "\n" + nonce + "\n" + timestamp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HMACCredentials returns a provider signing a new nonce for every handshake
+// with the secret of key, to be used as codec.Option.CredentialsProvider.
+func HMACCredentials(key string, secret []byte) func() (*codec.Credentials, error) {
+	return func() (*codec.Credentials, error) {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		nonce := hex.EncodeToString(b)
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		return &codec.Credentials{Scheme: SchemeHMAC, Values: map[string]string{
+			"key":       key,
+			"nonce":     nonce,
+			"timestamp": timestamp,
+			"signature": sign(secret, key, nonce, timestamp),
+		}}, nil
+	}
+}
+
+// HMAC returns an Authenticator verifying SchemeHMAC credentials against
+// keys. Signatures older or newer than window are rejected, and so is a
+// nonce seen within the window, so that a captured handshake can't be replayed.
+// window 0 means 5 minutes.
+func HMAC(keys map[string]HMACKey, window time.Duration) Authenticator {
+	if window == 0 {
+		window = time.Minute * 5
+	}
+	var mu sync.Mutex
+	seen := make(map[string]time.Time) // nonce -> time it expires from the window
+
+	return AuthenticatorFunc(func(ctx context.Context, creds *codec.Credentials) (*Principal, error) {
+		if creds == nil {
+			return nil, ErrMissingCredentials
+		}
+		if creds.Scheme != SchemeHMAC {
+			return nil, ErrUnsupportedScheme
+		}
+		key, nonce, timestamp := creds.Values["key"], creds.Values["nonce"], creds.Values["timestamp"]
+		k, ok := keys[key]
+		if !ok || nonce == "" {
+			return nil, ErrInvalidCredentials
+		}
+		expected := sign(k.Secret, key, nonce, timestamp)
+		if !hmac.Equal([]byte(expected), []byte(creds.Values["signature"])) {
+			return nil, ErrInvalidCredentials
+		}
+		sec, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidCredentials
+		}
+		now := time.Now()
+		if d := now.Sub(time.Unix(sec, 0)); d > window || d < -window {
+			return nil, ErrInvalidCredentials
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for n, expire := range seen {
+			if now.After(expire) {
+				delete(seen, n)
+			}
+		}
+		if _, replayed := seen[nonce]; replayed {
+			return nil, ErrInvalidCredentials
+		}
+		seen[nonce] = time.Unix(sec, 0).Add(window)
+		return k.Principal, nil
+	})
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"vrpc/status"
+)
+
+// ErrPermissionDenied is returned for calls a Policy doesn't allow.
+var ErrPermissionDenied = status.New(status.PermissionDenied, "permission denied")
+
+// Rule allows or denies calls to the methods matching Method.
+//
+// Method is either "Service.Method", "Service.*" for every method of a
+// service or "*" for every method. The rule applies to the callers named in
+// Principals or having one of Roles; "*" in Principals matches every
+// authenticated caller, and a rule with neither applies to every caller,
+// authenticated or not.
+type Rule struct {
+	Method     string
+	Principals []string
+	Roles      []string
+	Deny       bool
+}
+
+func (r *Rule) matchMethod(serviceMethod string) bool {
+	if r.Method == "*" || r.Method == serviceMethod {
+		return true
+	}
+	if strings.HasSuffix(r.Method, ".*") {
+		return strings.HasPrefix(serviceMethod, r.Method[:len(r.Method)-1])
+	}
+	return false
+}
+
+func (r *Rule) matchPrincipal(p *Principal) bool {
+	if len(r.Principals) == 0 && len(r.Roles) == 0 {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	for _, name := range r.Principals {
+		if name == "*" || name == p.Name {
+			return true
+		}
+	}
+	for _, role := range r.Roles {
+		if p.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy is an ordered list of rules, the first rule matching a call decides
+// whether it is allowed. Calls matched by no rule are denied unless
+// SetDefaultAllow says otherwise.
+type Policy struct {
+	mu           sync.RWMutex
+	rules        []Rule
+	defaultAllow bool
+}
+
+// NewPolicy returns a Policy denying what rules don't allow.
+func NewPolicy(rules ...Rule) *Policy {
+	return &Policy{rules: rules}
+}
+
+// Allow appends a rule allowing method to principals and to callers with roles.
+func (p *Policy) Allow(method string, principals []string, roles ...string) *Policy {
+	return p.Add(Rule{Method: method, Principals: principals, Roles: roles})
+}
+
+// Deny appends a rule denying method to principals and to callers with roles.
+func (p *Policy) Deny(method string, principals []string, roles ...string) *Policy {
+	return p.Add(Rule{Method: method, Principals: principals, Roles: roles, Deny: true})
+}
+
+// SetDefaultAllow sets whether calls matched by no rule are allowed,
+// it is safe to call while the policy is in use.
+func (p *Policy) SetDefaultAllow(allow bool) *Policy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.defaultAllow = allow
+	return p
+}
+
+// Add appends rules to the policy, it is safe to call while the policy is in use.
+func (p *Policy) Add(rules ...Rule) *Policy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rules = append(p.rules, rules...)
+	return p
+}
+
+// Check returns ErrPermissionDenied unless principal may call serviceMethod.
+// principal is nil for unauthenticated callers.
+func (p *Policy) Check(principal *Principal, serviceMethod string) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for i := range p.rules {
+		r := &p.rules[i]
+		if r.matchMethod(serviceMethod) && r.matchPrincipal(principal) {
+			if r.Deny {
+				return ErrPermissionDenied
+			}
+			return nil
+		}
+	}
+	if p.defaultAllow {
+		return nil
+	}
+	return ErrPermissionDenied
+}
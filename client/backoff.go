@@ -0,0 +1,49 @@
+package client
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff computes exponentially growing delays between attempts.
+// The zero value uses the defaults documented on each field.
+type Backoff struct {
+	Initial    time.Duration // delay before the second attempt, 0 means 100ms
+	Max        time.Duration // upper bound of the delay, 0 means 10s
+	Multiplier float64       // growth factor of the delay, 0 means 2
+	Jitter     float64       // the delay is randomized by up to ±Jitter of itself, 0 means 0.2
+}
+
+const (
+	defaultBackoffInitial    = time.Millisecond * 100
+	defaultBackoffMax        = time.Second * 10
+	defaultBackoffMultiplier = 2
+	defaultBackoffJitter     = 0.2
+)
+
+// Delay returns the delay before the retry following attempt, attempt starts with 0.
+func (b Backoff) Delay(attempt int) time.Duration {
+	initial, max, multiplier, jitter := b.Initial, b.Max, b.Multiplier, b.Jitter
+	if initial <= 0 {
+		initial = defaultBackoffInitial
+	}
+	if max <= 0 {
+		max = defaultBackoffMax
+	}
+	if multiplier <= 0 {
+		multiplier = defaultBackoffMultiplier
+	}
+	if jitter <= 0 {
+		jitter = defaultBackoffJitter
+	}
+
+	delay := float64(initial)
+	for i := 0; i < attempt && delay < float64(max); i++ {
+		delay *= multiplier
+	}
+	if delay > float64(max) {
+		delay = float64(max)
+	}
+	delay *= 1 + jitter*(rand.Float64()*2-1)
+	return time.Duration(delay)
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log"
@@ -8,6 +9,7 @@ import (
 	"strings"
 	"time"
 	"vrpc/codec"
+	"vrpc/status"
 )
 
 func parseOptions(opts ...*codec.Option) (*codec.Option, error) {
@@ -20,7 +22,9 @@ func parseOptions(opts ...*codec.Option) (*codec.Option, error) {
 		return nil, errors.New("number of options is more than 1")
 	}
 
-	opt := opts[0]
+	// work on a copy, callers such as XClient dial with the same options concurrently
+	o := *opts[0]
+	opt := &o
 	opt.MagicNumber = codec.DefaultOption.MagicNumber
 	if opt.CodecType == "" {
 		opt.CodecType = codec.DefaultOption.CodecType
@@ -42,10 +46,34 @@ func DialHTTP(network, address string, opts ...*codec.Option) (*Client, error) {
 	return dialTimeout(NewHTTPClient, network, address, opts...)
 }
 
+// DialTLS connects to an RPC server at the specified network address over TLS.
+// If config doesn't set ServerName, the host of address is verified.
+func DialTLS(network, address string, config *tls.Config, opts ...*codec.Option) (*Client, error) {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config = config.Clone()
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			config.ServerName = host
+		} else {
+			config.ServerName = address
+		}
+	}
+	return dialTimeout(func(conn net.Conn, opt *codec.Option) (*Client, error) {
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, err
+		}
+		return NewClient(tlsConn, opt)
+	}, network, address, opts...)
+}
+
 // XDial calls different functions to connect to a RPC server
 // according the first parameter rpcAddr.
 // rpcAddr is a general format (protocol@addr) to represent a rpc server
-// eg, http@10.0.0.1:7001, tcp@10.0.0.1:9999, unix@/tmp/geerpc.sock
+// eg, http@10.0.0.1:7001, tcp@10.0.0.1:9999, tls@10.0.0.1:9999, unix@/tmp/geerpc.sock
+// tls uses the TLSConfig of the option.
 func XDial(rpcAddr string, opts ...*codec.Option) (*Client, error) {
 	parts := strings.Split(rpcAddr, "@")
 	if len(parts) != 2 {
@@ -55,6 +83,12 @@ func XDial(rpcAddr string, opts ...*codec.Option) (*Client, error) {
 	switch protocol {
 	case "http":
 		return DialHTTP("tcp", addr, opts...)
+	case "tls":
+		opt, err := parseOptions(opts...)
+		if err != nil {
+			return nil, err
+		}
+		return DialTLS("tcp", addr, opt.TLSConfig, opt)
 	default:
 		// tcp, unix or other transport protocol
 		return Dial(protocol, addr, opts...)
@@ -73,9 +107,10 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*codec.Option
 		return nil, err
 	}
 
+	// 连接建立失败与 ErrShutdown 一样是 Unavailable, 只有调用方 ctx 的 deadline 才是 DeadlineExceeded
 	conn, err := net.DialTimeout(network, address, opt.ConnectTimeout)
 	if err != nil {
-		return nil, err
+		return nil, status.Wrap(status.Unavailable, err)
 	}
 
 	// close the connection if client is nil
@@ -91,15 +126,15 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*codec.Option
 		ch <- clientResult{client: client, err: err}
 	}()
 
-	log.Println("opt.ConnectTimeout:", opt.HandleTimeout)
-	if opt.HandleTimeout == 0 {
+	log.Println("opt.ConnectTimeout:", opt.ConnectTimeout)
+	if opt.ConnectTimeout == 0 {
 		result := <-ch
 		return result.client, result.err
 	}
 
 	select {
-	case <-time.After(opt.HandleTimeout):
-		return nil, fmt.Errorf("rpc client: connect timeout: expect within %s", opt.ConnectTimeout)
+	case <-time.After(opt.ConnectTimeout):
+		return nil, status.Errorf(status.Unavailable, "rpc client: connect timeout: expect within %s", opt.ConnectTimeout)
 	case result := <-ch:
 		return result.client, result.err
 	}
@@ -0,0 +1,249 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+	"vrpc/status"
+)
+
+// ErrCircuitOpen fails calls to a server whose circuit breaker is open.
+var ErrCircuitOpen = status.New(status.Unavailable, "rpc client: circuit breaker is open")
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	StateClosed   BreakerState = iota // calls go through
+	StateOpen                         // calls fail fast with ErrCircuitOpen
+	StateHalfOpen                     // a few trial calls go through to probe the server
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerPolicy controls when a circuit breaker opens and closes again.
+// The zero value uses the defaults documented on each field.
+type BreakerPolicy struct {
+	ConsecutiveFailures int           // failures in a row opening the circuit, 0 means 5, negative disables
+	FailureRate         float64       // ratio of failed calls in Window opening the circuit, 0 disables
+	MinRequests         int           // calls in Window before FailureRate applies, 0 means 10
+	Window              time.Duration // period over which FailureRate is computed, 0 means 10s
+	Cooldown            time.Duration // time the circuit stays open before trial calls, 0 means 5s
+	HalfOpenRequests    int           // concurrent trial calls while half-open, 0 means 1
+
+	// IsFailure reports whether a call error counts against the server,
+	// nil counts Unavailable, DeadlineExceeded, ResourceExhausted and Internal.
+	IsFailure func(err error) bool
+	// OnStateChange is called whenever the circuit of addr changes state.
+	OnStateChange func(addr string, from, to BreakerState)
+}
+
+const (
+	defaultBreakerFailures    = 5
+	defaultBreakerMinRequests = 10
+	defaultBreakerWindow      = time.Second * 10
+	defaultBreakerCooldown    = time.Second * 5
+)
+
+func isFailure(err error) bool {
+	switch status.CodeOf(err) {
+	case status.Unavailable, status.DeadlineExceeded, status.ResourceExhausted, status.Internal:
+		return true
+	}
+	return false
+}
+
+// Breaker is the circuit breaker of one server.
+type Breaker struct {
+	addr   string
+	policy BreakerPolicy
+
+	mu          sync.Mutex // protect following
+	state       BreakerState
+	consecutive int       // failures in a row
+	requests    int       // calls completed in the current window
+	failures    int       // failed calls in the current window
+	windowStart time.Time // start of the current window
+	openedAt    time.Time
+	generation  uint64   // incremented on every state change, results of calls allowed before are ignored
+	trials      int      // trial calls in flight while half-open
+	changes     []func() // state change callbacks to run once mu is released
+}
+
+// NewBreaker returns a closed circuit breaker for the server at addr.
+func NewBreaker(addr string, policy BreakerPolicy) *Breaker {
+	if policy.ConsecutiveFailures == 0 {
+		policy.ConsecutiveFailures = defaultBreakerFailures
+	}
+	if policy.MinRequests <= 0 {
+		policy.MinRequests = defaultBreakerMinRequests
+	}
+	if policy.Window <= 0 {
+		policy.Window = defaultBreakerWindow
+	}
+	if policy.Cooldown <= 0 {
+		policy.Cooldown = defaultBreakerCooldown
+	}
+	if policy.HalfOpenRequests <= 0 {
+		policy.HalfOpenRequests = 1
+	}
+	if policy.IsFailure == nil {
+		policy.IsFailure = isFailure
+	}
+	return &Breaker{addr: addr, policy: policy, windowStart: time.Now()}
+}
+
+// State returns the current state of the circuit.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Ready reports whether a call would be let through now, without reserving it.
+func (b *Breaker) Ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case StateOpen:
+		return time.Since(b.openedAt) >= b.policy.Cooldown
+	case StateHalfOpen:
+		return b.trials < b.policy.HalfOpenRequests
+	}
+	return true
+}
+
+// Allow reserves a call, returning ErrCircuitOpen if the circuit doesn't let
+// it through. The result of every call allowed must be reported once with done.
+func (b *Breaker) Allow() (done func(err error), err error) {
+	b.mu.Lock()
+	defer b.unlock()
+	if b.state == StateOpen && time.Since(b.openedAt) >= b.policy.Cooldown {
+		b.setState(StateHalfOpen)
+	}
+	trial := false
+	switch b.state {
+	case StateOpen:
+		return nil, ErrCircuitOpen
+	case StateHalfOpen:
+		if b.trials >= b.policy.HalfOpenRequests {
+			return nil, ErrCircuitOpen
+		}
+		b.trials++
+		trial = true
+	}
+	generation := b.generation
+	return func(err error) { b.done(generation, trial, err) }, nil
+}
+
+// done records the result of a call allowed in generation, as a trial call if trial.
+func (b *Breaker) done(generation uint64, trial bool, err error) {
+	failed := err != nil && b.policy.IsFailure(err)
+
+	b.mu.Lock()
+	defer b.unlock()
+	if generation != b.generation {
+		return // the circuit changed state since the call was allowed
+	}
+	if trial {
+		b.trials--
+		if failed {
+			b.open()
+		} else {
+			b.setState(StateClosed)
+		}
+		return
+	}
+
+	if now := time.Now(); now.Sub(b.windowStart) >= b.policy.Window {
+		b.requests, b.failures, b.windowStart = 0, 0, now
+	}
+	b.requests++
+	if !failed {
+		b.consecutive = 0
+		return
+	}
+	b.failures++
+	b.consecutive++
+	if (b.policy.ConsecutiveFailures > 0 && b.consecutive >= b.policy.ConsecutiveFailures) ||
+		(b.policy.FailureRate > 0 && b.requests >= b.policy.MinRequests &&
+			float64(b.failures)/float64(b.requests) >= b.policy.FailureRate) {
+		b.open()
+	}
+}
+
+func (b *Breaker) open() {
+	b.openedAt = time.Now()
+	b.setState(StateOpen)
+}
+
+func (b *Breaker) setState(state BreakerState) {
+	if b.state == state {
+		return
+	}
+	from := b.state
+	b.state = state
+	b.generation++
+	b.trials = 0
+	b.consecutive, b.requests, b.failures, b.windowStart = 0, 0, 0, time.Now()
+	if f := b.policy.OnStateChange; f != nil {
+		b.changes = append(b.changes, func() { f(b.addr, from, state) })
+	}
+}
+
+// unlock releases mu, then runs the state change callbacks, so that they may use b.
+func (b *Breaker) unlock() {
+	changes := b.changes
+	b.changes = nil
+	b.mu.Unlock()
+	for _, f := range changes {
+		f()
+	}
+}
+
+// CircuitBreaker returns an interceptor failing calls fast while b is open.
+func CircuitBreaker(b *Breaker) Interceptor {
+	return func(ctx context.Context, call *Call, invoke Invoker) error {
+		done, err := b.Allow()
+		if err != nil {
+			return err
+		}
+		err = invoke(ctx, call)
+		done(err)
+		return err
+	}
+}
+
+// Breakers holds a circuit breaker per server address, created on first use.
+type Breakers struct {
+	policy   BreakerPolicy
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewBreakers returns a set of circuit breakers sharing policy.
+func NewBreakers(policy BreakerPolicy) *Breakers {
+	return &Breakers{policy: policy, breakers: make(map[string]*Breaker)}
+}
+
+// Get returns the circuit breaker of addr.
+func (bs *Breakers) Get(addr string) *Breaker {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b, ok := bs.breakers[addr]
+	if !ok {
+		b = NewBreaker(addr, bs.policy)
+		bs.breakers[addr] = b
+	}
+	return b
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+	"vrpc/status"
+)
+
+func TestBreaker(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var changes []string
+	b := NewBreaker("tcp@a", BreakerPolicy{
+		ConsecutiveFailures: 3,
+		Cooldown:            time.Millisecond * 50,
+		OnStateChange: func(addr string, from, to BreakerState) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, addr+":"+from.String()+">"+to.String())
+		},
+	})
+	unavailable := status.New(status.Unavailable, "down")
+	call := func(err error) error {
+		done, e := b.Allow()
+		if e != nil {
+			return e
+		}
+		done(err)
+		return err
+	}
+
+	_ = call(unavailable)
+	_ = call(unavailable)
+	_ = call(status.New(status.NotFound, "not a server failure"))
+	_ = call(unavailable)
+	_ = call(unavailable)
+	_assert(b.State() == StateClosed, "expect application errors to reset the count")
+	_ = call(unavailable)
+	_assert(b.State() == StateOpen, "expect 3 failures in a row to open the circuit")
+	_assert(errors.Is(call(nil), ErrCircuitOpen), "expect calls to fail fast while open")
+
+	time.Sleep(time.Millisecond * 60)
+	_assert(b.Ready(), "expect a trial call after the cooldown")
+	done, err := b.Allow()
+	_assert(err == nil, "expect the trial call to go through")
+	_, err = b.Allow()
+	_assert(b.State() == StateHalfOpen && err == ErrCircuitOpen, "expect a single trial call")
+	done(unavailable)
+	_assert(b.State() == StateOpen, "expect a failed trial to open the circuit again")
+
+	time.Sleep(time.Millisecond * 60)
+	_assert(call(nil) == nil && b.State() == StateClosed, "expect a successful trial to close the circuit")
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"tcp@a:closed>open", "tcp@a:open>half-open", "tcp@a:half-open>open", "tcp@a:open>half-open", "tcp@a:half-open>closed"}
+	_assert(len(changes) == len(want), "unexpected state changes %v", changes)
+	for i := range want {
+		_assert(changes[i] == want[i], "unexpected state changes %v", changes)
+	}
+}
+
+func TestBreaker_FailureRate(t *testing.T) {
+	t.Parallel()
+	b := NewBreaker("tcp@a", BreakerPolicy{ConsecutiveFailures: -1, FailureRate: 0.5, MinRequests: 4})
+	for _, err := range []error{nil, status.New(status.Internal, "bug"), nil} {
+		done, _ := b.Allow()
+		done(err)
+	}
+	_assert(b.State() == StateClosed, "expect the rate to apply after MinRequests")
+	done, _ := b.Allow()
+	done(context.DeadlineExceeded)
+	_assert(b.State() == StateOpen, "expect 2 failures out of 4 calls to open the circuit")
+}
+
+func TestBreaker_StaleResults(t *testing.T) {
+	t.Parallel()
+	b := NewBreaker("tcp@a", BreakerPolicy{ConsecutiveFailures: 1, Cooldown: time.Millisecond * 20, HalfOpenRequests: 2})
+	unavailable := status.New(status.Unavailable, "down")
+
+	closedDone, _ := b.Allow() // allowed while closed, finishes during half-open
+	done, _ := b.Allow()
+	done(unavailable)
+	for cycle := 0; cycle < 3; cycle++ {
+		time.Sleep(time.Millisecond * 30)
+		first, err := b.Allow()
+		_assert(err == nil, "cycle %d: expect a trial call after the cooldown", cycle)
+		second, err := b.Allow()
+		_assert(err == nil, "cycle %d: expect a second trial call", cycle)
+		if cycle == 0 {
+			closedDone(nil)
+			_assert(b.State() == StateHalfOpen, "expect the result of a call allowed while closed to be ignored")
+		}
+		first(unavailable)
+		second(nil) // finishes after the circuit opened again
+		_assert(b.State() == StateOpen, "cycle %d: expect the failed trial to open the circuit", cycle)
+	}
+	time.Sleep(time.Millisecond * 30)
+	done, err := b.Allow()
+	_assert(err == nil, "expect trial slots not to leak across cycles")
+	done(nil)
+	_assert(b.State() == StateClosed, "expect a successful trial to close the circuit")
+}
@@ -1,12 +1,15 @@
 package client
 
+import "vrpc/metadata"
+
 // Call 代表一次 RPC.
 type Call struct {
-	Seq           uint64
+	Seq           uint64      // 请求的序列号; 经过拦截器时为最后一次尝试的序列号, call 完成时才设置
 	ServiceMethod string      // 指示服务名, 格式: "<service>.<method>"
 	Args          interface{} // 输入参数
 	Reply         interface{} // 服务的输出
 	Error         error       // if error occurs, it will be set
+	Trailer       metadata.MD // trailing metadata sent back by the server
 	Done          chan *Call  // Strobes when call is complete.
 }
 
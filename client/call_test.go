@@ -35,7 +35,8 @@ func TestClient_Call(t *testing.T) {
 	time.Sleep(time.Second)
 	t.Run("client timeout", func(t *testing.T) {
 		client, _ := Dial("tcp", addr)
-		ctx, _ := context.WithTimeout(context.Background(), time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
 		var reply int
 		err := client.Call(ctx, "Bar.Timeout", 1, &reply)
 		_assert(err != nil && strings.Contains(err.Error(), ctx.Err().Error()), "expect a timeout error")
@@ -50,3 +51,103 @@ func TestClient_Call(t *testing.T) {
 		_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect a timeout error")
 	})
 }
+
+type Baz int
+
+type Pair struct{ Key, Value string }
+
+func (b Baz) Double(argv int, reply *int) error {
+	*reply = argv * 2
+	return nil
+}
+
+func (b Baz) Swap(argv *Pair, reply *Pair) error {
+	reply.Key, reply.Value = argv.Value, argv.Key
+	return nil
+}
+
+func (b Baz) Index(argv []string, reply *map[string]int) error {
+	for i, s := range argv {
+		(*reply)[s] = i
+	}
+	return nil
+}
+
+func (b Baz) Keys(argv map[string]int, reply *[]string) error {
+	for k := range argv {
+		*reply = append(*reply, k)
+	}
+	return nil
+}
+
+func TestClient_CallJSON(t *testing.T) {
+	t.Parallel()
+	s := server.NewServer()
+	var b Baz
+	_ = s.Register(&b)
+	l, _ := net.Listen("tcp", ":0")
+	go s.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String(), &codec.Option{CodecType: codec.JsonType})
+	_assert(err == nil, "failed to dial with json codec: %v", err)
+	defer func() { _ = client.Close() }()
+
+	ctx := context.Background()
+	t.Run("value", func(t *testing.T) {
+		var reply int
+		err := client.Call(ctx, "Baz.Double", 21, &reply)
+		_assert(err == nil && reply == 42, "expect 42, got %d (%v)", reply, err)
+	})
+	t.Run("pointer", func(t *testing.T) {
+		var reply Pair
+		err := client.Call(ctx, "Baz.Swap", &Pair{Key: "k", Value: "v"}, &reply)
+		_assert(err == nil && reply == Pair{Key: "v", Value: "k"}, "unexpected reply %v (%v)", reply, err)
+	})
+	t.Run("slice to map", func(t *testing.T) {
+		var reply map[string]int
+		err := client.Call(ctx, "Baz.Index", []string{"a", "b"}, &reply)
+		_assert(err == nil && reply["a"] == 0 && reply["b"] == 1, "unexpected reply %v (%v)", reply, err)
+	})
+	t.Run("map to slice", func(t *testing.T) {
+		var reply []string
+		err := client.Call(ctx, "Baz.Keys", map[string]int{"a": 1}, &reply)
+		_assert(err == nil && len(reply) == 1 && reply[0] == "a", "unexpected reply %v (%v)", reply, err)
+	})
+	t.Run("error keeps stream in sync", func(t *testing.T) {
+		var reply int
+		err := client.Call(ctx, "Baz.Missing", 1, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "can't find method"), "expect a method error")
+		err = client.Call(ctx, "Baz.Double", 1, &reply)
+		_assert(err == nil && reply == 2, "expect 2, got %d (%v)", reply, err)
+	})
+}
+
+func TestClient_CallCompressed(t *testing.T) {
+	t.Parallel()
+	s := server.NewServer()
+	var b Baz
+	_ = s.Register(&b)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go s.Accept(l)
+
+	_, err := Dial("tcp", l.Addr().String(), &codec.Option{Compression: "zstd"})
+	_assert(err != nil && strings.Contains(err.Error(), "unknown compression"), "expect an unknown compression to fail")
+
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+		client, err := Dial("tcp", l.Addr().String(), &codec.Option{CodecType: typ, Compression: "gzip", CompressThreshold: 64})
+		_assert(err == nil, "failed to dial with compression: %v", err)
+		_assert(client.opt.Compression == "gzip", "expect gzip to be negotiated, got %q", client.opt.Compression)
+
+		keys := make([]string, 500)
+		for i := range keys {
+			keys[i] = strings.Repeat("k", 20) + string(rune('a'+i%26)) + strings.Repeat("v", i%7)
+		}
+		var reply map[string]int
+		err = client.Call(context.Background(), "Baz.Index", keys, &reply)
+		_assert(err == nil && len(reply) > 0, "unexpected reply of %d keys (%v)", len(reply), err)
+		var n int
+		err = client.Call(context.Background(), "Baz.Double", 21, &n)
+		_assert(err == nil && n == 42, "expect 42, got %d (%v)", n, err)
+		_ = client.Close()
+	}
+}
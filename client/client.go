@@ -3,7 +3,6 @@ package client
 import (
 	"bufio"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -11,7 +10,11 @@ import (
 	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 	"vrpc/codec"
+	"vrpc/metadata"
+	"vrpc/status"
 )
 
 // Client represents an RPC Client.
@@ -19,6 +22,7 @@ import (
 // with a single Client, and a Client may be used by
 // multiple goroutines simultaneously.
 type Client struct {
+	lastRecv int64 // 最近一次收到消息的时间 (UnixNano), 原子访问
 	cc       codec.Codec
 	header   codec.Header
 	opt      *codec.Option
@@ -28,11 +32,15 @@ type Client struct {
 	shutdown bool   // shutdown 是因为报错而产生的中断
 	seq      uint64 // 当前的序列号
 	pending  map[uint64]*Call
+
+	interceptors  []Interceptor
+	heartbeatLost int32 // 心跳超时导致连接被关闭时为 1, 原子访问
+	pinging       int32 // 正在发送 ping 时为 1, 原子访问
 }
 
 var _ io.Closer = (*Client)(nil)
 
-var ErrShutdown = errors.New("connection is shut down")
+var ErrShutdown = status.New(status.Unavailable, "connection is shut down")
 
 // Close the connection
 func (client *Client) Close() error {
@@ -105,34 +113,70 @@ func (client *Client) receive() {
 			break
 		}
 
+		atomic.StoreInt64(&client.lastRecv, time.Now().UnixNano())
+		if h.Type == codec.TypePong {
+			err = client.cc.ReadBody(nil)
+			continue
+		}
+
 		log.Println("client receive:", h, "header's seq:", h.Seq)
 		call := client.removeCall(h.Seq)
+		if call != nil {
+			call.Trailer = h.Metadata
+		}
 		switch {
 		case call == nil:
 			// call 不存在，可能是请求没有发送完整，或者因为其他原因被取消，但是服务端仍旧处理了。
 			err = client.cc.ReadBody(nil)
 		case h.Error != "":
 			// call 存在，但服务端处理出错，即 h.Error 不为空。
-			call.Error = fmt.Errorf(h.Error)
-			//err = client.cc.ReadBody(nil)
+			call.Error = remoteError(h.ErrorCode, h.Error, h.ErrorDetails)
+			err = client.cc.ReadBody(nil)
 			call.done()
 		default:
 			err = client.cc.ReadBody(call.Reply)
-			if err != nil {
-				call.Error = errors.New("reading body " + err.Error())
+			if errors.Is(err, codec.ErrBodyTooLarge) {
+				call.Error, err = err, nil // the body was skipped, the connection is still usable
+			} else if err != nil {
+				call.Error = status.Wrap(status.Internal, errors.New("reading body "+err.Error()))
 			}
 			call.done()
 		}
 	}
+	if atomic.LoadInt32(&client.heartbeatLost) == 1 {
+		err = ErrHeartbeatTimeout
+	}
 	// error occurs, so terminateCalls pending calls
+	if _, ok := err.(*status.Error); !ok {
+		err = status.Wrap(status.Unavailable, err)
+	}
 	client.terminateCalls(err)
 }
 
-func (client *Client) send(call *Call) {
+// remoteError rebuilds an error sent by the server.
+func remoteError(code uint32, msg string, details map[string]string) error {
+	c := status.Code(code)
+	if c == status.OK {
+		c = status.Unknown // the server didn't say
+	}
+	return &status.Error{Code: c, Message: msg, Details: details}
+}
+
+func (client *Client) send(ctx context.Context, call *Call) {
 	// make sure that the client will send a complete request
 	client.sending.Lock()
 	defer client.sending.Unlock()
 
+	// the server learns the caller's deadline as the time left
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			call.Error = status.New(status.DeadlineExceeded, "rpc client: call failed: "+context.DeadlineExceeded.Error())
+			call.done()
+			return
+		}
+	}
+
 	// register this call.
 	seq, err := client.registerCall(call)
 	if err != nil {
@@ -140,11 +184,15 @@ func (client *Client) send(call *Call) {
 		call.done()
 		return
 	}
+	log.Println("call's seq:", seq)
 
 	// prepare request header
 	client.header.ServiceMethod = call.ServiceMethod
 	client.header.Seq = seq
 	client.header.Error = ""
+	client.header.Type = codec.TypeCall
+	client.header.Timeout = timeout
+	client.header.Metadata, _ = metadata.FromOutgoingContext(ctx)
 
 	// encode and send the request
 	if err := client.cc.Write(&client.header, call.Args); err != nil {
@@ -166,15 +214,43 @@ func NewClient(conn net.Conn, opt *codec.Option) (*Client, error) {
 		log.Println("rpc client: codec error:", err)
 		return nil, err
 	}
+	if opt.Compression != "" && codec.GetCompressor(opt.Compression) == nil {
+		err := fmt.Errorf("unknown compression %s", opt.Compression)
+		log.Println("rpc client: codec error:", err)
+		return nil, err
+	}
 
-	// send options with server
-	if err := json.NewEncoder(conn).Encode(opt); err != nil {
+	// send options with server, along with fresh credentials if asked to
+	if opt.CredentialsProvider != nil {
+		creds, err := opt.CredentialsProvider()
+		if err != nil {
+			log.Println("rpc client: credentials error: ", err)
+			_ = conn.Close()
+			return nil, err
+		}
+		withCreds := *opt
+		withCreds.Credentials = creds
+		opt = &withCreds
+	}
+	if err := codec.WriteOption(conn, opt); err != nil {
 		log.Println("rpc client: options error: ", err)
 		_ = conn.Close()
 		return nil, err
 	}
 
-	return newClientCodec(f(conn), opt), nil
+	// the server replies with the negotiated options
+	negotiated, rwc, err := codec.ReadOption(conn)
+	if err != nil {
+		log.Println("rpc client: options error: ", err)
+		_ = conn.Close()
+		return nil, err
+	}
+	if negotiated.Error != "" {
+		_ = conn.Close()
+		return nil, remoteError(negotiated.ErrorCode, "rpc client: connection refused: "+negotiated.Error, nil)
+	}
+
+	return newClientCodec(f(rwc), negotiated), nil
 }
 
 const (
@@ -206,15 +282,45 @@ func newClientCodec(cc codec.Codec, opt *codec.Option) *Client {
 		opt:     opt,
 		pending: make(map[uint64]*Call),
 	}
+	client.lastRecv = time.Now().UnixNano()
+	codec.Configure(cc, opt)
 
 	go client.receive()
+	if opt.HeartbeatInterval > 0 {
+		go client.heartbeat(opt.HeartbeatInterval)
+	}
 
 	return client
 }
 
+// sendCancel tells the server that the call with seq has been given up,
+// so that the server can cancel the context of its handler.
+func (client *Client) sendCancel(seq uint64) {
+	client.sendControl(&codec.Header{Seq: seq, Type: codec.TypeCancel})
+}
+
+// sendControl sends a control message, which has no arguments and no call.
+func (client *Client) sendControl(h *codec.Header) {
+	if !client.IsAvailable() {
+		return
+	}
+	client.sending.Lock()
+	defer client.sending.Unlock()
+
+	if err := client.cc.Write(h, struct{}{}); err != nil {
+		log.Println("rpc client: send control message error:", err)
+	}
+}
+
 // Go invokes the function asynchronously.
 // It returns the Call structure representing the invocation.
+// The call goes through the interceptors of the client in the background.
 func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	return client.goContext(context.Background(), serviceMethod, args, reply, done)
+}
+
+// goContext is Go with the deadline and metadata of ctx sent along with the request.
+func (client *Client) goContext(ctx context.Context, serviceMethod string, args, reply interface{}, done chan *Call) *Call {
 	if done == nil {
 		done = make(chan *Call, 10)
 	} else if cap(done) == 0 {
@@ -226,20 +332,56 @@ func (client *Client) Go(serviceMethod string, args, reply interface{}, done cha
 		Reply:         reply,
 		Done:          done,
 	}
-	client.send(call)
+
+	client.mu.Lock()
+	interceptors := client.interceptors
+	client.mu.Unlock()
+	if len(interceptors) > 0 {
+		go client.intercept(ctx, call, interceptors)
+		return call
+	}
+
+	client.send(ctx, call)
 	return call
 }
 
 // Call invokes the named function, waits for it to complete,
 // and returns its error status.
+// The deadline and outgoing metadata of ctx are propagated to the server,
+// and the server is told to cancel the call once ctx is canceled.
+// The trailing metadata of the response is stored as set up by WithTrailer.
 func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1))
-	log.Println("call's seq:", call.Seq)
+	call := client.goContext(ctx, serviceMethod, args, reply, make(chan *Call, 1))
+	return client.wait(ctx, call)
+}
+
+// wait blocks until call completes or ctx is done, in which case the server
+// is told to cancel the call unless it has reached its deadline.
+func (client *Client) wait(ctx context.Context, call *Call) error {
 	select {
 	case <-ctx.Done():
-		client.removeCall(call.Seq)
-		return errors.New("rpc client: call failed: " + ctx.Err().Error())
+		// the server enforces the deadline it was sent on its own, only a
+		// cancellation needs to be told
+		// Seq of an intercepted call is written by the interceptor goroutine
+		client.mu.Lock()
+		seq := call.Seq
+		client.mu.Unlock()
+		if client.removeCall(seq) != nil && ctx.Err() != context.DeadlineExceeded {
+			client.sendCancel(seq)
+		}
+		return status.Wrap(status.CodeOf(ctx.Err()), errors.New("rpc client: call failed: "+ctx.Err().Error()))
 	case call := <-call.Done:
+		if md, ok := ctx.Value(trailerKey{}).(*metadata.MD); ok {
+			*md = call.Trailer
+		}
 		return call.Error
 	}
 }
+
+type trailerKey struct{}
+
+// WithTrailer returns a context with which Call stores the trailing metadata
+// of the response into md.
+func WithTrailer(ctx context.Context, md *metadata.MD) context.Context {
+	return context.WithValue(ctx, trailerKey{}, md)
+}
@@ -39,19 +39,14 @@ func TestClient_dialTimeout(t *testing.T) {
 
 func TestXDial(t *testing.T) {
 	if runtime.GOOS == "linux" {
-		ch := make(chan struct{})
 		addr := "/tmp/geerpc.sock"
-		go func() {
-			_ = os.Remove(addr)
-			l, err := net.Listen("unix", addr)
-			if err != nil {
-				t.Fatal("failed to listen unix socket")
-			}
-			ch <- struct{}{}
-			server.Accept(l)
-		}()
-		<-ch
-		_, err := XDial("unix@" + addr)
+		_ = os.Remove(addr)
+		l, err := net.Listen("unix", addr)
+		if err != nil {
+			t.Fatal("failed to listen unix socket")
+		}
+		go server.Accept(l)
+		_, err = XDial("unix@" + addr)
 		_assert(err == nil, "failed to connect unix socket")
 	}
 }
@@ -0,0 +1,41 @@
+package client
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+	"vrpc/codec"
+	"vrpc/status"
+)
+
+// ErrHeartbeatTimeout fails the pending calls of a connection closed because
+// the server stopped answering heartbeats.
+var ErrHeartbeatTimeout = status.New(status.Unavailable, "rpc client: heartbeat timeout")
+
+// heartbeat sends a ping every interval, and closes the connection once
+// nothing has been received for codec.MissedHeartbeats intervals, so that
+// the pending calls fail instead of waiting for a half-open connection.
+func (client *Client) heartbeat(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		if !client.IsAvailable() {
+			return
+		}
+		lastRecv := time.Unix(0, atomic.LoadInt64(&client.lastRecv))
+		if time.Since(lastRecv) > interval*codec.MissedHeartbeats {
+			log.Println("rpc client: heartbeat timeout, close the connection")
+			atomic.StoreInt32(&client.heartbeatLost, 1)
+			_ = client.cc.Close()
+			return
+		}
+		// a ping waits for the request being sent, which may be stuck on a
+		// half-open connection, so the check above must not wait for it
+		if atomic.CompareAndSwapInt32(&client.pinging, 0, 1) {
+			go func() {
+				defer atomic.StoreInt32(&client.pinging, 0)
+				client.sendControl(&codec.Header{Type: codec.TypePing})
+			}()
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+	"vrpc/codec"
+	"vrpc/server"
+)
+
+func TestClient_Heartbeat(t *testing.T) {
+	t.Parallel()
+	s := server.NewServer()
+	var b Baz
+	_ = s.Register(&b)
+	s.SetHeartbeatInterval(time.Millisecond * 40)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go s.Accept(l)
+
+	t.Run("negotiated", func(t *testing.T) {
+		client, err := Dial("tcp", l.Addr().String(), &codec.Option{})
+		_assert(err == nil, "dial error: %v", err)
+		defer func() { _ = client.Close() }()
+		_assert(client.opt.HeartbeatInterval == time.Millisecond*40, "expect the server interval, got %s", client.opt.HeartbeatInterval)
+
+		// idle for longer than the server tolerates without pings
+		time.Sleep(time.Millisecond * 300)
+		var reply int
+		err = client.Call(context.Background(), "Baz.Double", 1, &reply)
+		_assert(err == nil && reply == 2, "expect pings to keep the connection alive, got %v", err)
+	})
+	t.Run("smaller client interval wins", func(t *testing.T) {
+		client, err := Dial("tcp", l.Addr().String(), &codec.Option{HeartbeatInterval: time.Millisecond * 20})
+		_assert(err == nil, "dial error: %v", err)
+		defer func() { _ = client.Close() }()
+		_assert(client.opt.HeartbeatInterval == time.Millisecond*20, "expect the client interval, got %s", client.opt.HeartbeatInterval)
+	})
+}
+
+func TestClient_HeartbeatTimeout(t *testing.T) {
+	t.Parallel()
+	// a server that completes the handshake and then never answers
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		opt, _, _ := codec.ReadOption(conn)
+		_ = codec.WriteOption(conn, opt)
+	}()
+
+	client, err := Dial("tcp", l.Addr().String(), &codec.Option{HeartbeatInterval: time.Millisecond * 30})
+	_assert(err == nil, "dial error: %v", err)
+	call := client.Go("Baz.Double", 1, new(int), nil)
+	select {
+	case <-call.Done:
+		_assert(call.Error == ErrHeartbeatTimeout, "expect ErrHeartbeatTimeout, got %v", call.Error)
+	case <-time.After(time.Second):
+		t.Fatal("expect the pending call to fail once heartbeats are missed")
+	}
+	_assert(!client.IsAvailable(), "expect the client to be unavailable")
+}
+
+func TestClient_HeartbeatTimeoutWhileSending(t *testing.T) {
+	t.Parallel()
+	// a server that completes the handshake and then stops reading
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		opt, _, _ := codec.ReadOption(conn)
+		_ = codec.WriteOption(conn, opt)
+		accepted <- conn
+	}()
+
+	client, err := Dial("tcp", l.Addr().String(), &codec.Option{HeartbeatInterval: time.Millisecond * 30})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = (<-accepted).Close() }()
+
+	// the request fills the send buffers and blocks in Write
+	done := make(chan *Call, 1)
+	go client.Go("Baz.Len", make([]byte, 32<<20), new(int), done)
+	select {
+	case call := <-done:
+		_assert(call.Error != nil, "expect the stuck call to fail")
+	case <-time.After(time.Second):
+		t.Fatal("expect missed pongs to close the connection while a send is stuck")
+	}
+	// the pending calls are terminated once the receiver sees the connection closed
+	for i := 0; i < 100 && client.IsAvailable(); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	_assert(!client.IsAvailable(), "expect the client to be unavailable")
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+)
+
+// Invoker sends call and waits for it to complete, returning call.Error.
+type Invoker func(ctx context.Context, call *Call) error
+
+// Interceptor wraps every call made through Call and Go.
+// It may change ctx (e.g. its metadata) before calling invoke, call invoke
+// more than once to retry, or return an error without calling invoke to
+// fail the call before anything is written to the connection.
+type Interceptor func(ctx context.Context, call *Call, invoke Invoker) error
+
+// Use appends interceptors to the chain run around every call of the client,
+// interceptors run in registration order.
+// Use must be called before the client issues any call.
+func (client *Client) Use(interceptors ...Interceptor) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.interceptors = append(client.interceptors, interceptors...)
+}
+
+// intercept runs call through the interceptors and completes it with their result.
+func (client *Client) intercept(ctx context.Context, call *Call, interceptors []Interceptor) {
+	invoke := Invoker(client.invoke)
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoke
+		invoke = func(ctx context.Context, call *Call) error {
+			return interceptor(ctx, call, next)
+		}
+	}
+	call.Error = invoke(ctx, call)
+	call.done()
+}
+
+// invoke is the innermost Invoker, every invocation sends a new request
+// on behalf of call, whose Seq becomes that of the last request.
+func (client *Client) invoke(ctx context.Context, call *Call) error {
+	attempt := &Call{
+		ServiceMethod: call.ServiceMethod,
+		Args:          call.Args,
+		Reply:         call.Reply,
+		Done:          make(chan *Call, 1),
+	}
+	client.send(ctx, attempt)
+	call.Error = client.wait(ctx, attempt)
+	call.Trailer = attempt.Trailer
+	client.mu.Lock()
+	call.Seq = attempt.Seq
+	client.mu.Unlock()
+	return call.Error
+}
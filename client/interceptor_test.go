@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"vrpc/metadata"
+	"vrpc/server"
+)
+
+type Tenant int
+
+// Name replies with the tenant id in the metadata of the call
+func (t Tenant) Name(ctx context.Context, argv int, reply *string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	*reply = md.Get("tenant")
+	return nil
+}
+
+func TestClient_Use(t *testing.T) {
+	t.Parallel()
+	s := server.NewServer()
+	var tn Tenant
+	_ = s.Register(&tn)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go s.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var invocations, failures int32
+	errDenied := errors.New("denied")
+	client.Use(
+		func(ctx context.Context, call *Call, invoke Invoker) error {
+			if call.Args.(int) < 0 {
+				return errDenied // fail fast, nothing is sent
+			}
+			return invoke(metadata.AppendToOutgoingContext(ctx, "tenant", "acme"), call)
+		},
+		func(ctx context.Context, call *Call, invoke Invoker) error {
+			// retry once if the first attempt failed
+			atomic.AddInt32(&invocations, 1)
+			if err := invoke(ctx, call); err == nil || call.ServiceMethod != "Tenant.Missing" {
+				return err
+			}
+			atomic.AddInt32(&failures, 1)
+			return invoke(ctx, call)
+		},
+	)
+
+	t.Run("call", func(t *testing.T) {
+		var reply string
+		err := client.Call(context.Background(), "Tenant.Name", 1, &reply)
+		_assert(err == nil && reply == "acme", "expect metadata set by interceptor, got %q (%v)", reply, err)
+	})
+	t.Run("go", func(t *testing.T) {
+		var reply string
+		call := <-client.Go("Tenant.Name", 1, &reply, nil).Done
+		_assert(call.Error == nil && reply == "acme", "expect Go to run interceptors, got %q (%v)", reply, call.Error)
+		_assert(call.Seq != 0, "expect the seq of the request sent for the call")
+	})
+	t.Run("fail fast", func(t *testing.T) {
+		before := atomic.LoadInt32(&invocations)
+		var reply string
+		err := client.Call(context.Background(), "Tenant.Name", -1, &reply)
+		_assert(err == errDenied, "expect the interceptor error, got %v", err)
+		_assert(atomic.LoadInt32(&invocations) == before, "expect inner interceptors to be skipped")
+	})
+	t.Run("retry", func(t *testing.T) {
+		var reply string
+		err := client.Call(context.Background(), "Tenant.Missing", 1, &reply)
+		_assert(err != nil && atomic.LoadInt32(&failures) == 1, "expect a retried failure, got %v", err)
+	})
+}
@@ -0,0 +1,340 @@
+package client
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"time"
+	"vrpc/codec"
+)
+
+// PoolConfig controls the connections a Pool keeps to its address.
+// The zero value uses the defaults documented on each field.
+type PoolConfig struct {
+	Size        int           // maximum number of connections, 0 means 4
+	MinIdle     int           // connections kept open even when idle, they are redialed if they break
+	MaxIdle     int           // idle connections kept open past IdleTimeout, 0 means Size
+	IdleTimeout time.Duration // time after which connections beyond MaxIdle are closed, 0 means 1min
+}
+
+const (
+	defaultPoolSize        = 4
+	defaultPoolIdleTimeout = time.Minute
+)
+
+// pooledConn is a connection of a pool along with its usage.
+type pooledConn struct {
+	client   *Client
+	reserved int       // calls handed out by get that haven't been registered in client.pending yet
+	lastUsed time.Time // time the last call completed
+}
+
+// loadLocked returns the number of outstanding calls on the connection: those
+// in client.pending, including calls made on the client directly, plus those
+// about to be sent through the pool.
+func (pc *pooledConn) loadLocked() int {
+	pc.client.mu.Lock()
+	defer pc.client.mu.Unlock()
+	return len(pc.client.pending) + pc.reserved
+}
+
+// Pool spreads calls to one address over several connections, so that a
+// large request or reply doesn't hold up the others. Every call goes to the
+// connection with the fewest outstanding calls; while all are busy, a new
+// connection is dialed in the background, up to Size.
+// Broken connections are dropped and replaced on demand.
+type Pool struct {
+	dial   func() (*Client, error)
+	config PoolConfig
+
+	mu           sync.Mutex // protect following
+	conns        []*pooledConn
+	dialing      int // connections being dialed
+	interceptors []Interceptor
+	closed       bool
+	closeCh      chan struct{} // stops the maintenance of the pool on Close
+}
+
+var _ io.Closer = (*Pool)(nil)
+
+// DialPool connects to an RPC server at the specified network address with
+// up to config.Size connections, at least one of which is dialed right away.
+func DialPool(network, address string, config PoolConfig, opts ...*codec.Option) (*Pool, error) {
+	if config.Size <= 0 {
+		config.Size = defaultPoolSize
+	}
+	if config.MinIdle > config.Size {
+		config.MinIdle = config.Size
+	}
+	if config.MaxIdle <= 0 || config.MaxIdle > config.Size {
+		config.MaxIdle = config.Size
+	}
+	if config.MaxIdle < config.MinIdle {
+		config.MaxIdle = config.MinIdle
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = defaultPoolIdleTimeout
+	}
+	return newPool(func() (*Client, error) {
+		return Dial(network, address, opts...)
+	}, config)
+}
+
+// newPool returns a pool of connections made by dial, config must have its defaults applied.
+func newPool(dial func() (*Client, error), config PoolConfig) (*Pool, error) {
+	p := &Pool{
+		dial:    dial,
+		config:  config,
+		closeCh: make(chan struct{}),
+	}
+
+	c, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	p.conns = append(p.conns, &pooledConn{client: c, lastUsed: time.Now()})
+	p.fill()
+	go p.maintain()
+	return p, nil
+}
+
+// Use appends interceptors to the chain of the current and every future connection.
+func (p *Pool) Use(interceptors ...Interceptor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.interceptors = append(p.interceptors, interceptors...)
+	for _, pc := range p.conns {
+		pc.client.Use(interceptors...)
+	}
+}
+
+// Close closes every connection of the pool.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrShutdown
+	}
+	p.closed = true
+	close(p.closeCh)
+	for _, pc := range p.conns {
+		_ = pc.client.Close()
+	}
+	p.conns = nil
+	return nil
+}
+
+// IsAvailable return true if the pool has not been closed,
+// broken connections are redialed by the next call.
+func (p *Pool) IsAvailable() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.closed
+}
+
+// Len returns the number of open connections.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removeDeadLocked()
+	return len(p.conns)
+}
+
+// removeDeadLocked drops the connections that are no longer available.
+func (p *Pool) removeDeadLocked() {
+	conns := p.conns[:0]
+	for _, pc := range p.conns {
+		if pc.client.IsAvailable() {
+			conns = append(conns, pc)
+		} else {
+			_ = pc.client.Close()
+		}
+	}
+	for i := len(conns); i < len(p.conns); i++ {
+		p.conns[i] = nil
+	}
+	p.conns = conns
+}
+
+// addLocked adds a newly dialed client to the pool.
+func (p *Pool) addLocked(c *Client) *pooledConn {
+	c.Use(p.interceptors...)
+	pc := &pooledConn{client: c, lastUsed: time.Now()}
+	p.conns = append(p.conns, pc)
+	return pc
+}
+
+// dialedLocked accounts for a dial started by the pool, keeping c unless
+// the pool was closed meanwhile.
+func (p *Pool) dialedLocked(c *Client, err error) (*pooledConn, error) {
+	p.dialing--
+	if err != nil {
+		return nil, err
+	}
+	if p.closed {
+		_ = c.Close()
+		return nil, ErrShutdown
+	}
+	return p.addLocked(c), nil
+}
+
+// get reserves the connection with the fewest outstanding calls. If they are
+// all busy and the pool isn't full, a new one is dialed in the background,
+// the call waits for it only if there is no connection at all.
+func (p *Pool) get() (*pooledConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrShutdown
+	}
+	p.removeDeadLocked()
+
+	var best *pooledConn
+	bestLoad := 0
+	for _, pc := range p.conns {
+		if load := pc.loadLocked(); best == nil || load < bestLoad {
+			best, bestLoad = pc, load
+		}
+	}
+	switch {
+	case best == nil:
+		// don't hold up the other calls while dialing
+		p.dialing++
+		p.mu.Unlock()
+		c, err := p.dial()
+		p.mu.Lock()
+		if best, err = p.dialedLocked(c, err); err != nil {
+			return nil, err
+		}
+	case bestLoad > 0 && len(p.conns)+p.dialing < p.config.Size:
+		p.dialing++
+		go p.grow()
+	}
+	best.reserved++
+	return best, nil
+}
+
+// grow dials a connection in the background for get.
+func (p *Pool) grow() {
+	c, err := p.dial()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err = p.dialedLocked(c, err); err != nil && err != ErrShutdown {
+		log.Println("rpc client: pool dial error:", err)
+	}
+}
+
+// sent releases the reservation of get once the call is in client.pending.
+func (p *Pool) sent(pc *pooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pc.reserved--
+}
+
+func (p *Pool) put(pc *pooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pc.lastUsed = time.Now()
+}
+
+// Call invokes the named function on the least busy connection, waits for it
+// to complete, and returns its error status.
+func (p *Pool) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	pc, err := p.get()
+	if err != nil {
+		return NotSent(err)
+	}
+	defer p.put(pc)
+	call := pc.client.goContext(ctx, serviceMethod, args, reply, make(chan *Call, 1))
+	p.sent(pc)
+	return pc.client.wait(ctx, call)
+}
+
+// Go invokes the function asynchronously, see Call.
+func (p *Pool) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	if done == nil {
+		done = make(chan *Call, 10)
+	} else if cap(done) == 0 {
+		log.Panic("rpc client: done channel is unbuffered")
+	}
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          done,
+	}
+	go func() {
+		call.Error = p.Call(context.Background(), serviceMethod, args, reply)
+		call.done()
+	}()
+	return call
+}
+
+// fill dials connections until the pool has MinIdle of them.
+func (p *Pool) fill() {
+	for {
+		p.mu.Lock()
+		p.removeDeadLocked()
+		if p.closed || len(p.conns)+p.dialing >= p.config.MinIdle {
+			p.mu.Unlock()
+			return
+		}
+		p.dialing++
+		p.mu.Unlock()
+
+		c, err := p.dial()
+		p.mu.Lock()
+		_, err = p.dialedLocked(c, err)
+		p.mu.Unlock()
+		if err != nil {
+			if err != ErrShutdown {
+				log.Println("rpc client: pool dial error:", err)
+			}
+			return
+		}
+	}
+}
+
+// shrink closes the connections idle for longer than IdleTimeout beyond MaxIdle.
+func (p *Pool) shrink() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removeDeadLocked()
+	idle := make([]bool, len(p.conns))
+	numIdle := 0
+	for i, pc := range p.conns {
+		if idle[i] = pc.loadLocked() == 0; idle[i] {
+			numIdle++
+		}
+	}
+	conns := p.conns[:0]
+	for i, pc := range p.conns {
+		if numIdle > p.config.MaxIdle && idle[i] && time.Since(pc.lastUsed) >= p.config.IdleTimeout {
+			_ = pc.client.Close()
+			numIdle--
+			continue
+		}
+		conns = append(conns, pc)
+	}
+	for i := len(conns); i < len(p.conns); i++ {
+		p.conns[i] = nil
+	}
+	p.conns = conns
+}
+
+// maintain periodically closes surplus idle connections and redials broken
+// ones below MinIdle, until the pool is closed.
+func (p *Pool) maintain() {
+	t := time.NewTicker(p.config.IdleTimeout / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			p.shrink()
+			p.fill()
+		case <-p.closeCh:
+			return
+		}
+	}
+}
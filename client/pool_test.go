@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	t.Parallel()
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	_ = l.Close()
+	startSlowServer(t, addr)
+
+	p, err := DialPool("tcp", addr, PoolConfig{Size: 4, MinIdle: 1, MaxIdle: 1, IdleTimeout: time.Millisecond * 200})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = p.Close() }()
+	_assert(p.Len() == 1, "expect MinIdle connections, got %d", p.Len())
+
+	t.Run("spread", func(t *testing.T) {
+		var wg sync.WaitGroup
+		start := time.Now()
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var reply int
+				_ = p.Call(context.Background(), "Slow.Sleep", time.Millisecond*300, &reply)
+			}()
+		}
+		time.Sleep(time.Millisecond * 100)
+		_assert(p.Len() == 4, "expect connections dialed for the concurrent calls, got %d", p.Len())
+		wg.Wait()
+		_assert(time.Since(start) < time.Millisecond*600, "expect the calls to run side by side")
+	})
+	t.Run("idle", func(t *testing.T) {
+		time.Sleep(time.Millisecond * 500)
+		_assert(p.Len() == 1, "expect idle connections beyond MaxIdle to be closed, got %d", p.Len())
+	})
+	t.Run("replace", func(t *testing.T) {
+		p.mu.Lock()
+		_ = p.conns[0].client.Close()
+		p.mu.Unlock()
+		var reply int
+		err := p.Call(context.Background(), "Slow.Sleep", time.Millisecond, &reply)
+		_assert(err == nil && reply == 1, "expect a new connection to serve the call, got %v", err)
+		_assert(p.Len() == 1, "expect the broken connection to be replaced, got %d", p.Len())
+	})
+}
+
+func TestPool_DialInBackground(t *testing.T) {
+	t.Parallel()
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	_ = l.Close()
+	startSlowServer(t, addr)
+
+	var mu sync.Mutex
+	dials := 0
+	p, err := newPool(func() (*Client, error) {
+		mu.Lock()
+		dials++
+		slow := dials > 1
+		mu.Unlock()
+		if slow {
+			time.Sleep(time.Millisecond * 300)
+		}
+		return Dial("tcp", addr)
+	}, PoolConfig{Size: 2, MaxIdle: 2, IdleTimeout: time.Minute})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = p.Close() }()
+
+	go func() {
+		var reply int
+		_ = p.Call(context.Background(), "Slow.Sleep", time.Millisecond*200, &reply)
+	}()
+	time.Sleep(time.Millisecond * 20)
+	start := time.Now()
+	var reply int
+	err = p.Call(context.Background(), "Slow.Sleep", time.Millisecond, &reply)
+	_assert(err == nil && time.Since(start) < time.Millisecond*150, "expect the busy connection to serve the call while dialing, took %s (%v)", time.Since(start), err)
+	time.Sleep(time.Millisecond * 350)
+	_assert(p.Len() == 2, "expect a connection dialed in the background, got %d", p.Len())
+}
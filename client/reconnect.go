@@ -0,0 +1,236 @@
+package client
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"time"
+	"vrpc/codec"
+)
+
+// ReconnectPolicy controls how a ReconnectClient redials a broken connection.
+type ReconnectPolicy struct {
+	Backoff
+	MaxAttempts int // dials tried before a call fails with the dial error, 0 means until ctx is done
+}
+
+// ReconnectClient is a Client that redials its network address with backoff
+// once the connection breaks, redoing the Option handshake.
+// Calls in flight when the connection breaks fail, unless their ctx is
+// marked by WithIdempotent, in which case they are sent again on the new
+// connection. Calls that never reached the connection are always retried.
+type ReconnectClient struct {
+	dial         func() (*Client, error)
+	policy       ReconnectPolicy
+	mu           sync.Mutex // protect following
+	client       *Client
+	interceptors []Interceptor
+	retry        *RetryPolicy
+	closed       bool
+	closeCh      chan struct{} // interrupts redialing on Close
+	dialing      chan struct{} // 不为 nil 时正在重连, 重连结束后关闭
+}
+
+var _ io.Closer = (*ReconnectClient)(nil)
+
+// DialReconnect connects to an RPC server at the specified network address
+// and reconnects to it according to policy whenever the connection breaks.
+func DialReconnect(network, address string, policy ReconnectPolicy, opts ...*codec.Option) (*ReconnectClient, error) {
+	rc := &ReconnectClient{
+		dial: func() (*Client, error) {
+			return Dial(network, address, opts...)
+		},
+		policy:  policy,
+		closeCh: make(chan struct{}),
+	}
+	c, err := rc.dial()
+	if err != nil {
+		return nil, err
+	}
+	rc.client = c
+	return rc, nil
+}
+
+type idempotentKey struct{}
+
+// WithIdempotent marks the calls made with the returned context as safe to
+// send again if the connection breaks before their response arrives.
+func WithIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+func isIdempotent(ctx context.Context) bool {
+	idempotent, _ := ctx.Value(idempotentKey{}).(bool)
+	return idempotent
+}
+
+// Use appends interceptors to the chain of the current and every future connection.
+func (rc *ReconnectClient) Use(interceptors ...Interceptor) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.interceptors = append(rc.interceptors, interceptors...)
+	if rc.client != nil {
+		rc.client.Use(interceptors...)
+	}
+}
+
+// Close closes the connection and stops reconnecting.
+func (rc *ReconnectClient) Close() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.closed {
+		return ErrShutdown
+	}
+	rc.closed = true
+	close(rc.closeCh)
+	if rc.client != nil {
+		return rc.client.Close()
+	}
+	return nil
+}
+
+// IsAvailable return true if the client has not been closed,
+// a broken connection is redialed by the next call.
+func (rc *ReconnectClient) IsAvailable() bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return !rc.closed
+}
+
+// get returns a working client, redialing with backoff if the connection broke.
+// Redialing doesn't hold rc.mu, callers arriving meanwhile wait for it to end.
+func (rc *ReconnectClient) get(ctx context.Context) (*Client, error) {
+	for {
+		rc.mu.Lock()
+		if rc.closed {
+			rc.mu.Unlock()
+			return nil, ErrShutdown
+		}
+		if rc.client != nil && rc.client.IsAvailable() {
+			c := rc.client
+			rc.mu.Unlock()
+			return c, nil
+		}
+		if dialing := rc.dialing; dialing != nil {
+			rc.mu.Unlock()
+			select {
+			case <-dialing: // look again, and redial if it failed
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if rc.client != nil {
+			_ = rc.client.Close()
+			rc.client = nil
+		}
+		dialing := make(chan struct{})
+		rc.dialing = dialing
+		policy := rc.policy
+		rc.mu.Unlock()
+
+		c, err := rc.redial(ctx, policy)
+
+		rc.mu.Lock()
+		rc.dialing = nil
+		close(dialing)
+		if err == nil {
+			if rc.closed {
+				_ = c.Close()
+				c, err = nil, ErrShutdown
+			} else {
+				c.Use(rc.interceptors...)
+				rc.client = c
+			}
+		}
+		rc.mu.Unlock()
+		return c, err
+	}
+}
+
+// redial dials with backoff until it succeeds, policy gives up, ctx is done or rc is closed.
+func (rc *ReconnectClient) redial(ctx context.Context, policy ReconnectPolicy) (*Client, error) {
+	var err error
+	for attempt := 0; policy.MaxAttempts == 0 || attempt < policy.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(policy.Delay(attempt - 1)):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-rc.closeCh:
+				return nil, ErrShutdown
+			}
+		}
+		var c *Client
+		if c, err = rc.dial(); err == nil {
+			return c, nil
+		}
+		log.Println("rpc client: reconnect error:", err)
+	}
+	return nil, err
+}
+
+// SetRetryPolicy makes calls failing with an error retryable by policy be
+// sent again, after reconnecting if needed. Without a policy only calls whose
+// connection broke are sent again, see ReconnectClient.
+func (rc *ReconnectClient) SetRetryPolicy(policy *RetryPolicy) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.retry = policy
+}
+
+// Call invokes the named function, waits for it to complete,
+// and returns its error status, reconnecting first if needed.
+func (rc *ReconnectClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	rc.mu.Lock()
+	policy := rc.retry
+	rc.mu.Unlock()
+	if policy == nil {
+		return rc.call(ctx, serviceMethod, args, reply)
+	}
+	return policy.Do(ctx, serviceMethod, func(int) error {
+		return rc.call(ctx, serviceMethod, args, reply)
+	})
+}
+
+func (rc *ReconnectClient) call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	for {
+		c, err := rc.get(ctx)
+		if err == ErrShutdown {
+			return err
+		} else if err != nil {
+			return NotSent(err)
+		}
+		err = c.Call(ctx, serviceMethod, args, reply)
+		switch {
+		case err == nil || c.IsAvailable() || ctx.Err() != nil:
+			return err
+		case err == ErrShutdown:
+			// the connection was already broken, the call was never sent
+		case !isIdempotent(ctx):
+			return err
+		}
+		log.Println("rpc client: connection broken, retry", serviceMethod)
+	}
+}
+
+// Go invokes the function asynchronously, see Call.
+func (rc *ReconnectClient) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	if done == nil {
+		done = make(chan *Call, 10)
+	} else if cap(done) == 0 {
+		log.Panic("rpc client: done channel is unbuffered")
+	}
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          done,
+	}
+	go func() {
+		call.Error = rc.Call(context.Background(), serviceMethod, args, reply)
+		call.done()
+	}()
+	return call
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+	"vrpc/server"
+)
+
+type Slow int
+
+func (s Slow) Sleep(d time.Duration, reply *int) error {
+	time.Sleep(d)
+	*reply = 1
+	return nil
+}
+
+func startSlowServer(t *testing.T, addr string) *server.Server {
+	s := server.NewServer()
+	var sl Slow
+	_ = s.Register(&sl)
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	go s.Accept(l)
+	return s
+}
+
+// killServer shuts s down without waiting for in-flight calls
+func killServer(s *server.Server) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = s.Shutdown(ctx)
+}
+
+func TestReconnectClient(t *testing.T) {
+	t.Parallel()
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	s := startSlowServer(t, addr)
+	rc, err := DialReconnect("tcp", addr, ReconnectPolicy{Backoff: Backoff{Initial: time.Millisecond * 20}})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = rc.Close() }()
+
+	var reply int
+	_assert(rc.Call(context.Background(), "Slow.Sleep", time.Duration(0), &reply) == nil, "expect the first call to succeed")
+
+	t.Run("in-flight call fails", func(t *testing.T) {
+		call := rc.Go("Slow.Sleep", time.Second, &reply, nil)
+		time.Sleep(time.Millisecond * 50)
+		killServer(s)
+		<-call.Done
+		_assert(call.Error != nil, "expect a non-idempotent in-flight call to fail")
+	})
+
+	restarted := make(chan *server.Server, 1)
+	t.Run("redial with backoff", func(t *testing.T) {
+		time.AfterFunc(time.Millisecond*100, func() { restarted <- startSlowServer(t, addr) })
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+		defer cancel()
+		err := rc.Call(ctx, "Slow.Sleep", time.Duration(0), &reply)
+		_assert(err == nil, "expect the call to succeed after reconnecting, got %v", err)
+		s = <-restarted
+	})
+
+	t.Run("idempotent in-flight call is retried", func(t *testing.T) {
+		old := s
+		time.AfterFunc(time.Millisecond*50, func() {
+			killServer(old)
+			restarted <- startSlowServer(t, addr)
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+		defer cancel()
+		reply = 0
+		err := rc.Call(WithIdempotent(ctx), "Slow.Sleep", time.Millisecond*200, &reply)
+		_assert(err == nil && reply == 1, "expect the idempotent call to be retried, got %v", err)
+		s = <-restarted
+	})
+
+	t.Run("give up after max attempts", func(t *testing.T) {
+		killServer(s)
+		rc.mu.Lock()
+		rc.policy.MaxAttempts = 2
+		rc.mu.Unlock()
+		err := rc.Call(context.Background(), "Slow.Sleep", time.Duration(0), &reply)
+		_assert(err != nil, "expect the call to fail once redialing gives up")
+	})
+
+	t.Run("close interrupts redialing", func(t *testing.T) {
+		rc.mu.Lock()
+		rc.policy.MaxAttempts = 0
+		rc.mu.Unlock()
+		call := rc.Go("Slow.Sleep", time.Duration(0), &reply, nil) // redials until closed
+		time.Sleep(time.Millisecond * 50)
+		_assert(rc.IsAvailable(), "expect the client to be available while redialing")
+		closed := make(chan error, 1)
+		go func() { closed <- rc.Close() }()
+		select {
+		case err := <-closed:
+			_assert(err == nil, "close error: %v", err)
+		case <-time.After(time.Millisecond * 500):
+			t.Fatal("expect Close not to wait for redialing")
+		}
+		select {
+		case <-call.Done:
+			_assert(call.Error == ErrShutdown, "expect ErrShutdown, got %v", call.Error)
+		case <-time.After(time.Millisecond * 500):
+			t.Fatal("expect Close to stop redialing")
+		}
+	})
+}
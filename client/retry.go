@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"vrpc/status"
+)
+
+// RetryPolicy controls how failed calls are sent again.
+//
+// A call is retried when its error has one of Codes and either the call
+// never reached a server, or it is idempotent: its method matches one of
+// Idempotent or its ctx is marked by WithIdempotent. Retries stop once
+// MaxAttempts is reached or the ctx deadline would pass before the next one.
+type RetryPolicy struct {
+	Backoff
+	MaxAttempts int           // attempts including the first one, 0 means 3
+	Codes       []status.Code // retryable codes, nil means status.Unavailable only
+	Idempotent  []string      // methods safe to send again: "Service.Method", "Service.*" or "*"
+}
+
+const defaultRetryMaxAttempts = 3
+
+// errNotSent wraps the error of a call that never reached a server.
+type errNotSent struct{ error }
+
+func (e errNotSent) Unwrap() error { return e.error }
+
+// NotSent marks err as the error of a call that was never sent, so that it is
+// retried even if the call isn't idempotent. Dial errors should be marked.
+func NotSent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return errNotSent{err}
+}
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts <= 0 {
+		return defaultRetryMaxAttempts
+	}
+	return p.MaxAttempts
+}
+
+func (p *RetryPolicy) isIdempotent(ctx context.Context, serviceMethod string) bool {
+	if isIdempotent(ctx) {
+		return true
+	}
+	for _, m := range p.Idempotent {
+		if m == "*" || m == serviceMethod ||
+			(strings.HasSuffix(m, ".*") && strings.HasPrefix(serviceMethod, m[:len(m)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// Retryable reports whether a call of serviceMethod failing with err may be sent again.
+func (p *RetryPolicy) Retryable(ctx context.Context, serviceMethod string, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	codes := p.Codes
+	if codes == nil {
+		codes = []status.Code{status.Unavailable}
+	}
+	code, retryable := status.CodeOf(err), false
+	for _, c := range codes {
+		if c == code {
+			retryable = true
+			break
+		}
+	}
+	if !retryable {
+		return false
+	}
+	var notSent errNotSent
+	return errors.Is(err, ErrShutdown) || errors.As(err, &notSent) || p.isIdempotent(ctx, serviceMethod)
+}
+
+// Do calls attempt until it succeeds or its error isn't retryable, waiting
+// with backoff between attempts. attempt receives the number of the attempt,
+// starting with 0.
+func (p *RetryPolicy) Do(ctx context.Context, serviceMethod string, attempt func(n int) error) error {
+	var err error
+	for n := 0; ; n++ {
+		if err = attempt(n); err == nil || n+1 >= p.maxAttempts() || !p.Retryable(ctx, serviceMethod, err) {
+			return err
+		}
+		delay := p.Delay(n)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err // the retry couldn't complete in time
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+	}
+}
+
+// Retry returns an interceptor sending calls again according to policy.
+func Retry(policy *RetryPolicy) Interceptor {
+	return func(ctx context.Context, call *Call, invoke Invoker) error {
+		return policy.Do(ctx, call.ServiceMethod, func(int) error {
+			return invoke(ctx, call)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+	"vrpc/server"
+	"vrpc/status"
+)
+
+// Flaky fails with Unavailable until it has been called Fails times
+type Flaky struct {
+	Fails int32
+	calls int32
+}
+
+func (f *Flaky) Get(argv int, reply *int) error {
+	if atomic.AddInt32(&f.calls, 1) <= f.Fails {
+		return status.New(status.Unavailable, "try again")
+	}
+	*reply = argv
+	return nil
+}
+
+func (f *Flaky) Put(argv int, reply *int) error { return f.Get(argv, reply) }
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+	s := server.NewServer()
+	f := &Flaky{Fails: 2}
+	_ = s.Register(f)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go s.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	policy := &RetryPolicy{
+		Backoff:     Backoff{Initial: time.Millisecond * 10},
+		MaxAttempts: 3,
+		Idempotent:  []string{"Flaky.Get"},
+	}
+	client.Use(Retry(policy))
+
+	t.Run("idempotent", func(t *testing.T) {
+		atomic.StoreInt32(&f.calls, 0)
+		var reply int
+		err := client.Call(context.Background(), "Flaky.Get", 7, &reply)
+		_assert(err == nil && reply == 7, "expect success on the 3rd attempt, got %d (%v)", reply, err)
+		_assert(atomic.LoadInt32(&f.calls) == 3, "expect 3 attempts, got %d", f.calls)
+	})
+	t.Run("not idempotent", func(t *testing.T) {
+		atomic.StoreInt32(&f.calls, 0)
+		var reply int
+		err := client.Call(context.Background(), "Flaky.Put", 7, &reply)
+		_assert(errors.Is(err, status.Unavailable), "expect Unavailable, got %v", err)
+		_assert(atomic.LoadInt32(&f.calls) == 1, "expect no retry, got %d attempts", f.calls)
+
+		atomic.StoreInt32(&f.calls, 0)
+		err = client.Call(WithIdempotent(context.Background()), "Flaky.Put", 7, &reply)
+		_assert(err == nil, "expect WithIdempotent to allow retries, got %v", err)
+	})
+	t.Run("max attempts", func(t *testing.T) {
+		atomic.StoreInt32(&f.calls, -10)
+		var reply int
+		err := client.Call(context.Background(), "Flaky.Get", 7, &reply)
+		_assert(errors.Is(err, status.Unavailable), "expect Unavailable, got %v", err)
+		_assert(atomic.LoadInt32(&f.calls) == -7, "expect 3 attempts, got %d", f.calls+10)
+	})
+	t.Run("deadline", func(t *testing.T) {
+		atomic.StoreInt32(&f.calls, 0)
+		slow := *policy
+		slow.Initial = time.Second
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+		defer cancel()
+		start := time.Now()
+		err := Retry(&slow)(ctx, &Call{ServiceMethod: "Flaky.Get", Args: 1, Reply: new(int)}, client.invoke)
+		_assert(errors.Is(err, status.Unavailable), "expect the last error, got %v", err)
+		_assert(time.Since(start) < time.Millisecond*400, "expect no wait past the deadline")
+	})
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+	"vrpc/codec"
+	"vrpc/server"
+)
+
+// testCA issues certificates signed by a self-signed CA generated in-process
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "vrpc test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("create ca error:", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal("issue certificate error:", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+type Whoami int
+
+// Name replies with the common name of the verified client certificate
+func (w Whoami) Name(ctx context.Context, argv int, reply *string) error {
+	if p, ok := server.PeerFromContext(ctx); ok && p.Certificate() != nil {
+		*reply = p.Certificate().Subject.CommonName
+	}
+	return nil
+}
+
+func TestDialTLS(t *testing.T) {
+	t.Parallel()
+	ca := newTestCA(t)
+	serverCert := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	clientCert := ca.issue(t, "alice", x509.ExtKeyUsageClientAuth)
+
+	s := server.NewServer()
+	var w Whoami
+	_ = s.Register(&w)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go s.AcceptTLS(l, server.MutualTLSConfig(serverCert, ca.pool))
+	addr := l.Addr().String()
+
+	t.Run("mutual tls", func(t *testing.T) {
+		client, err := DialTLS("tcp", addr, &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{clientCert}})
+		_assert(err == nil, "dial error: %v", err)
+		defer func() { _ = client.Close() }()
+		var reply string
+		err = client.Call(context.Background(), "Whoami.Name", 0, &reply)
+		_assert(err == nil && reply == "alice", "expect the handler to see the client certificate, got %q (%v)", reply, err)
+	})
+	t.Run("xdial", func(t *testing.T) {
+		client, err := XDial("tls@"+addr, &codec.Option{
+			TLSConfig: &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{clientCert}},
+		})
+		_assert(err == nil, "xdial error: %v", err)
+		_ = client.Close()
+	})
+	t.Run("no client certificate", func(t *testing.T) {
+		client, err := DialTLS("tcp", addr, &tls.Config{RootCAs: ca.pool})
+		if err == nil {
+			// with TLS 1.3 the server may reject the client after the handshake completed
+			var reply string
+			err = client.Call(context.Background(), "Whoami.Name", 0, &reply)
+		}
+		_assert(err != nil, "expect the server to reject a client without certificate")
+	})
+	t.Run("untrusted server", func(t *testing.T) {
+		_, err := DialTLS("tcp", addr, &tls.Config{Certificates: []tls.Certificate{clientCert}})
+		_assert(err != nil, "expect the client to reject an unknown server certificate")
+	})
+}
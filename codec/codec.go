@@ -1,14 +1,32 @@
 package codec
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 // Header 典型的 RPC 调用格式: err = client.Call("Arith.Multiply", args, &reply)
 type Header struct {
 	ServiceMethod string // 格式: [服务名].[方法名]
 	Seq           uint64 // 序列号
 	Error         string
+	ErrorCode     uint32            // Error 的错误码, 见 vrpc/status
+	ErrorDetails  map[string]string // Error 的附加信息
+	Type          MessageType       // 消息类型, 零值表示普通的请求或响应
+	Timeout       time.Duration     // 距离调用方 deadline 的剩余时间, 0 表示没有 deadline; 使用相对时间以避免两端时钟不一致
+	Metadata      map[string]string // 请求中为调用方的 metadata, 响应中为服务端的 trailing metadata
 }
 
+// MessageType distinguishes control messages from calls.
+type MessageType uint8
+
+const (
+	TypeCall   MessageType = iota // request or response of a call
+	TypeCancel                    // the client gave up the call with the same Seq, sent without ServiceMethod
+	TypePing                      // heartbeat sent by the client, sent without ServiceMethod
+	TypePong                      // reply of the server to a ping with the same Seq
+)
+
 type Codec interface {
 	io.Closer
 	ReadHeader(*Header) error
@@ -22,7 +40,7 @@ type Type string
 
 const (
 	GobType  Type = "application/gob"
-	JsonType Type = "application/json" // not implemented
+	JsonType Type = "application/json"
 )
 
 var NewCodecFuncMap map[Type]NewCodecFunc
@@ -30,4 +48,5 @@ var NewCodecFuncMap map[Type]NewCodecFunc
 func init() {
 	NewCodecFuncMap = make(map[Type]NewCodecFunc)
 	NewCodecFuncMap[GobType] = NewGobCodec
+	NewCodecFuncMap[JsonType] = NewJsonCodec
 }
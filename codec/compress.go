@@ -0,0 +1,110 @@
+package codec
+
+import (
+	"compress/gzip"
+	"io"
+	"sync"
+)
+
+// Compressor compresses the bodies of messages. Compressors are selected by
+// name in Option.Compression, see RegisterCompressor.
+type Compressor interface {
+	Name() string
+	// Compress returns a writer compressing to w, the data is complete once it is closed.
+	Compress(w io.Writer) (io.WriteCloser, error)
+	// Decompress returns a reader decompressing from r, closed once the body is read.
+	Decompress(r io.Reader) (io.ReadCloser, error)
+}
+
+// DefaultCompressThreshold is the size below which bodies are sent uncompressed
+// when Option.CompressThreshold is 0.
+const DefaultCompressThreshold = 1024
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = make(map[string]Compressor)
+)
+
+// RegisterCompressor makes c available to connections under its name,
+// replacing the compressor registered with the same name, if any.
+// Both sides of a connection must register it for it to be used.
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[c.Name()] = c
+}
+
+// GetCompressor returns the compressor registered as name, nil if there is none.
+func GetCompressor(name string) Compressor {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	return compressors[name]
+}
+
+// CompressionSetter is implemented by codecs able to compress bodies.
+// Bodies of threshold bytes or more are compressed with c, nil disables
+// compression; compressed bodies are decompressed whatever the threshold.
+type CompressionSetter interface {
+	SetCompression(c Compressor, threshold int)
+}
+
+func init() {
+	RegisterCompressor(&gzipCompressor{})
+	RegisterCompressor(&snappyCompressor{})
+}
+
+// gzipCompressor reuses its writers and readers, which are costly to allocate.
+type gzipCompressor struct {
+	writers sync.Pool
+	readers sync.Pool
+}
+
+func (c *gzipCompressor) Name() string { return "gzip" }
+
+func (c *gzipCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	zw, ok := c.writers.Get().(*gzip.Writer)
+	if !ok {
+		zw = gzip.NewWriter(w)
+	} else {
+		zw.Reset(w)
+	}
+	return &pooledWriter{WriteCloser: zw, put: func() { c.writers.Put(zw) }}, nil
+}
+
+func (c *gzipCompressor) Decompress(r io.Reader) (io.ReadCloser, error) {
+	zr, ok := c.readers.Get().(*gzip.Reader)
+	if !ok {
+		var err error
+		if zr, err = gzip.NewReader(r); err != nil {
+			return nil, err
+		}
+	} else if err := zr.Reset(r); err != nil {
+		c.readers.Put(zr)
+		return nil, err
+	}
+	return &pooledReader{Reader: zr, put: func() { c.readers.Put(zr) }}, nil
+}
+
+// pooledWriter returns its writer to the pool once closed.
+type pooledWriter struct {
+	io.WriteCloser
+	put func()
+}
+
+func (w *pooledWriter) Close() error {
+	err := w.WriteCloser.Close()
+	w.put()
+	return err
+}
+
+// pooledReader returns its reader to the pool once closed.
+type pooledReader struct {
+	*gzip.Reader
+	put func()
+}
+
+func (r *pooledReader) Close() error {
+	err := r.Reader.Close()
+	r.put()
+	return err
+}
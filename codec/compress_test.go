@@ -0,0 +1,111 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// identity is a plugin compressor that counts the bodies it compresses
+type identity struct{ n int }
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func (c *identity) Name() string { return "identity" }
+
+func (c *identity) Compress(w io.Writer) (io.WriteCloser, error) {
+	c.n++
+	return nopCloser{w}, nil
+}
+
+func (c *identity) Decompress(r io.Reader) (io.ReadCloser, error) { return ioutil.NopCloser(r), nil }
+
+func TestFrameCodec_Compression(t *testing.T) {
+	plugin := new(identity)
+	RegisterCompressor(plugin)
+	// the registry is shared by the whole package, don't leak the plugin into other tests
+	defer func() {
+		compressorsMu.Lock()
+		delete(compressors, plugin.Name())
+		compressorsMu.Unlock()
+	}()
+	if GetCompressor("identity") != plugin {
+		t.Fatal("expect the plugin to be registered")
+	}
+
+	big := strings.Repeat("repetitive ", 1000)
+	for _, name := range []string{"gzip", "snappy"} {
+		conn := new(pipe)
+		w, r := NewFrameCodec(conn, NewGobMarshaler()), NewFrameCodec(conn, NewGobMarshaler())
+		w.SetCompression(GetCompressor(name), 0)
+		r.SetCompression(GetCompressor(name), 0)
+
+		_ = w.Write(&Header{Seq: 1}, big)
+		if conn.Len() > len(big)/10 {
+			t.Fatalf("%s: expect a repetitive body to be compressed, got %d bytes", name, conn.Len())
+		}
+		var h Header
+		var body string
+		if err := r.ReadHeader(&h); err != nil || r.ReadBody(&body) != nil || body != big {
+			t.Fatalf("%s: expect the body to round trip (%v)", name, err)
+		}
+
+		_ = w.Write(&Header{Seq: 2}, "small")
+		if conn.Bytes()[1]&flagCompressed != 0 {
+			t.Fatalf("%s: expect bodies below the threshold to be sent as is", name)
+		}
+		if err := r.ReadHeader(&h); err != nil || r.ReadBody(&body) != nil || body != "small" {
+			t.Fatalf("%s: unexpected body %q (%v)", name, body, err)
+		}
+
+		// the limit applies to the decompressed body
+		r.SetMaxSize(0, 1024)
+		_ = w.Write(&Header{Seq: 3}, big)
+		if err := r.ReadHeader(&h); err != nil || r.ReadBody(&body) != ErrBodyTooLarge {
+			t.Fatalf("%s: expect ErrBodyTooLarge, got %v", name, err)
+		}
+	}
+
+	conn := new(pipe)
+	w, r := NewFrameCodec(conn, JsonMarshaler{}), NewFrameCodec(conn, JsonMarshaler{})
+	w.SetCompression(plugin, 1)
+	r.SetCompression(plugin, 1)
+	_ = w.Write(&Header{Seq: 1}, "plugin")
+	var h Header
+	var body string
+	if err := r.ReadHeader(&h); err != nil || r.ReadBody(&body) != nil || body != "plugin" || plugin.n != 1 {
+		t.Fatalf("expect the plugin to be used, got %q (%v)", body, err)
+	}
+}
+
+func TestSnappy_RoundTrip(t *testing.T) {
+	c := GetCompressor("snappy")
+	random := make([]byte, 1<<16)
+	for i := range random {
+		random[i] = byte(i*7919 + i>>3*31)
+	}
+	for _, in := range [][]byte{nil, []byte("a"), []byte(strings.Repeat("ab", 40000)), random} {
+		var buf bytes.Buffer
+		w, _ := c.Compress(&buf)
+		_, _ = w.Write(in)
+		_ = w.Close()
+		r, err := c.Decompress(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil || !bytes.Equal(out, in) {
+			t.Fatalf("expect %d bytes to round trip, got %d (%v)", len(in), len(out), err)
+		}
+	}
+
+	// a copy reaching before the start of the block
+	r, _ := c.Decompress(bytes.NewReader([]byte{4, 0x01, 0x05, 0x00}))
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Fatal("expect corrupt input to fail")
+	}
+}
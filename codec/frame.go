@@ -0,0 +1,297 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+)
+
+// 每个消息是一个帧, header 和 body 分别带有长度前缀:
+//
+//	| version (1) | flags (1) | header length (4) | body length (4) | seq (8) | header | body |
+//
+// 长度和 seq 均为大端序. 因为 body 的长度事先已知, 读取方可以跳过 body 而不解码它;
+// seq 即 header 的 Seq, 读取方跳过过大的 header 时仍然可以回复这个请求.
+// flags 的 flagCompressed 位表示 body 经过了压缩, 见 Compressor;
+// flagNewStream 位表示 header 开始了一个新的 header 流, 见 HeaderStream.
+const (
+	FrameVersion    = 1
+	frameHeaderSize = 18
+	maxFrameSize    = 1 << 30 // header 或 body 的长度上限, 超出时长度字段必然已损坏
+
+	flagCompressed byte = 1 << 0
+	flagNewStream  byte = 1 << 1
+)
+
+var (
+	// ErrFrameVersion is returned when reading a frame of an unknown version.
+	ErrFrameVersion = errors.New("rpc codec: unsupported frame version")
+	// ErrFrameLength is returned when reading a frame whose length fields are corrupt,
+	// the connection can't be read any further.
+	ErrFrameLength = errors.New("rpc codec: corrupt frame length")
+)
+
+// Marshaler encodes the header and the body of a message, each on its own.
+type Marshaler interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// HeaderStream is implemented by Marshalers encoding the headers of a
+// connection as one stream rather than each on its own, e.g. to send type
+// definitions only once. Such a Marshaler serves a single connection.
+// Bodies are still encoded each on its own, so that they can be skipped and
+// forwarded.
+type HeaderStream interface {
+	Marshaler
+	ResetEncoder() // the next header encoded starts a new stream
+	ResetDecoder() // the next header decoded starts a new stream
+}
+
+// RawBody is a body kept encoded: reading into a *RawBody stores the body
+// as received, writing a RawBody sends it as is, so that it can be
+// forwarded without decoding it.
+type RawBody []byte
+
+// FrameCodec is a Codec writing each message as a frame, with the header and
+// the body encoded by a Marshaler.
+type FrameCodec struct {
+	conn      io.ReadWriteCloser
+	r         *bufio.Reader
+	buf       *bufio.Writer
+	m         Marshaler
+	prefix    [frameHeaderSize]byte
+	bodyLen   int  // 当前帧尚未读取的 body 长度
+	flags     byte // 当前帧的 flags
+	maxHeader int  // 0 表示不限制
+	maxBody   int
+	newStream bool // 编码过的 header 没有发送出去, 下一个 header 需要开始新的 header 流
+
+	compressor Compressor // nil 表示不压缩
+	threshold  int        // 小于该字节数的 body 不压缩
+}
+
+var _ Codec = (*FrameCodec)(nil)
+var _ SizeLimiter = (*FrameCodec)(nil)
+var _ CompressionSetter = (*FrameCodec)(nil)
+
+// NewFrameCodec returns a FrameCodec encoding messages on conn with m.
+func NewFrameCodec(conn io.ReadWriteCloser, m Marshaler) *FrameCodec {
+	return &FrameCodec{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		buf:  bufio.NewWriter(conn),
+		m:    m,
+	}
+}
+
+func (c *FrameCodec) Close() error {
+	return c.conn.Close()
+}
+
+// SetMaxSize 设置 header 和 body 的最大字节数, 超出限制的消息会被跳过, 连接仍然可用
+func (c *FrameCodec) SetMaxSize(header, body int) {
+	c.maxHeader, c.maxBody = header, body
+}
+
+func exceeds(n, max int) bool {
+	return n > maxFrameSize || (max > 0 && n > max)
+}
+
+// SetCompression 设置压缩 body 所用的算法和阈值, threshold 为 0 时使用 DefaultCompressThreshold
+func (c *FrameCodec) SetCompression(compressor Compressor, threshold int) {
+	if threshold == 0 {
+		threshold = DefaultCompressThreshold
+	}
+	c.compressor, c.threshold = compressor, threshold
+}
+
+// ReadHeader 读取下一帧的 prefix 和 header, 上一帧未读取的 body 会被跳过.
+// header 过大时返回 ErrHeaderTooLarge, h 中只有 Seq 被设置.
+func (c *FrameCodec) ReadHeader(h *Header) error {
+	if err := c.skipBody(); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(c.r, c.prefix[:]); err != nil {
+		return err
+	}
+	if c.prefix[0] != FrameVersion {
+		return fmt.Errorf("%w %d", ErrFrameVersion, c.prefix[0])
+	}
+	c.flags = c.prefix[1]
+	headerLen := int(binary.BigEndian.Uint32(c.prefix[2:6]))
+	c.bodyLen = int(binary.BigEndian.Uint32(c.prefix[6:10]))
+	if headerLen > maxFrameSize || c.bodyLen > maxFrameSize {
+		// 不要试图跳过一个损坏的长度
+		c.bodyLen = 0
+		return ErrFrameLength
+	}
+	if hs, ok := c.m.(HeaderStream); ok && c.flags&flagNewStream != 0 {
+		hs.ResetDecoder()
+	}
+	if exceeds(headerLen, c.maxHeader) {
+		if _, err := c.r.Discard(headerLen); err != nil {
+			return err
+		}
+		h.Seq = binary.BigEndian.Uint64(c.prefix[10:18]) // 以便读取方回复这个请求
+		return ErrHeaderTooLarge
+	}
+	data := make([]byte, headerLen)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return err
+	}
+	return c.m.Unmarshal(data, h)
+}
+
+// ReadBody 将 body 从 conn 读取到 body 变量, body 为 nil 时跳过该 body 而不解码
+func (c *FrameCodec) ReadBody(body interface{}) error {
+	if body == nil || exceeds(c.bodyLen, c.maxBody) {
+		tooLarge := body != nil
+		if err := c.skipBody(); err != nil {
+			return err
+		}
+		if tooLarge {
+			return ErrBodyTooLarge
+		}
+		return nil
+	}
+	data := make([]byte, c.bodyLen)
+	c.bodyLen = 0
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return err
+	}
+	if c.flags&flagCompressed != 0 {
+		var err error
+		if data, err = c.decompress(data); err != nil {
+			return err
+		}
+	}
+	if raw, ok := body.(*RawBody); ok {
+		*raw = data
+		return nil
+	}
+	return c.m.Unmarshal(data, body)
+}
+
+// decompress 解压 body, 解压后的大小同样受 maxBody 限制
+func (c *FrameCodec) decompress(data []byte) ([]byte, error) {
+	if c.compressor == nil {
+		return nil, errors.New("rpc codec: compressed body on a connection without compression")
+	}
+	r, err := c.compressor.Decompress(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	max := c.maxBody
+	if max <= 0 {
+		max = maxFrameSize
+	}
+	out, err := ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > max {
+		return nil, ErrBodyTooLarge
+	}
+	return out, nil
+}
+
+// compress 压缩不小于阈值的 body, 压缩后没有变小时仍发送原始数据
+func (c *FrameCodec) compress(data []byte) ([]byte, bool, error) {
+	if c.compressor == nil || len(data) < c.threshold {
+		return data, false, nil
+	}
+	var buf bytes.Buffer
+	w, err := c.compressor.Compress(&buf)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err = w.Write(data); err != nil {
+		_ = w.Close()
+		return nil, false, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, false, err
+	}
+	if buf.Len() >= len(data) {
+		return data, false, nil
+	}
+	return buf.Bytes(), true, nil
+}
+
+func (c *FrameCodec) skipBody() error {
+	n := c.bodyLen
+	c.bodyLen = 0
+	if n > c.r.Size() {
+		_, err := io.CopyN(ioutil.Discard, c.r, int64(n))
+		return err
+	}
+	_, err := c.r.Discard(n)
+	return err
+}
+
+// Write 将 header 和 body 编码后作为一帧写入到 buf.
+// header 和 body 先被完整编码, 编码失败或超出大小限制时什么也不写.
+// header 最后编码: 对 HeaderStream 来说, 编码了却没有发送的 header 会让下一个 header 开始新的流.
+func (c *FrameCodec) Write(h *Header, body interface{}) (err error) {
+	defer func() {
+		if ferr := c.buf.Flush(); ferr != nil {
+			err = ferr
+			_ = c.Close()
+		}
+	}()
+
+	b, ok := body.(RawBody)
+	if !ok {
+		if b, err = c.m.Marshal(body); err != nil {
+			log.Println("rpc codec: error encoding body: ", err)
+			return
+		}
+	}
+	if exceeds(len(b), c.maxBody) {
+		return ErrBodyTooLarge
+	}
+	b, compressed, err := c.compress(b)
+	if err != nil {
+		log.Println("rpc codec: error compressing body: ", err)
+		return
+	}
+
+	var prefix [frameHeaderSize]byte
+	prefix[0] = FrameVersion
+	if compressed {
+		prefix[1] |= flagCompressed
+	}
+	hs, streamed := c.m.(HeaderStream)
+	if streamed && c.newStream {
+		hs.ResetEncoder()
+		prefix[1] |= flagNewStream
+	}
+	c.newStream = streamed // the header may not be sent
+	hb, err := c.m.Marshal(h)
+	if err != nil {
+		log.Println("rpc codec: error encoding header: ", err)
+		return
+	}
+	if exceeds(len(hb), c.maxHeader) {
+		return ErrHeaderTooLarge
+	}
+	c.newStream = false
+
+	binary.BigEndian.PutUint32(prefix[2:6], uint32(len(hb)))
+	binary.BigEndian.PutUint32(prefix[6:10], uint32(len(b)))
+	binary.BigEndian.PutUint64(prefix[10:18], h.Seq)
+	for _, p := range [][]byte{prefix[:], hb, b} {
+		if _, err = c.buf.Write(p); err != nil {
+			log.Println("rpc codec: error writing frame: ", err)
+			return
+		}
+	}
+	return
+}
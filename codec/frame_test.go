@@ -0,0 +1,94 @@
+package codec
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFrameCodec(t *testing.T) {
+	for typ, f := range NewCodecFuncMap {
+		conn := new(pipe)
+		w, r := f(conn), f(conn)
+		_ = w.Write(&Header{ServiceMethod: "Foo.Skip", Seq: 1}, map[string]int{"skipped": 1})
+		_ = w.Write(&Header{ServiceMethod: "Foo.Raw", Seq: 2}, []string{"a", "b"})
+		_ = w.Write(&Header{ServiceMethod: "Foo.Next", Seq: 3}, 42)
+
+		var h Header
+		if err := r.ReadHeader(&h); err != nil || h.Seq != 1 {
+			t.Fatalf("%s: unexpected header %+v (%v)", typ, h, err)
+		}
+		if err := r.ReadBody(nil); err != nil {
+			t.Fatalf("%s: expect the body to be skipped, got %v", typ, err)
+		}
+
+		// forward the raw body to another connection and decode it there
+		var raw RawBody
+		if err := r.ReadHeader(&h); err != nil || r.ReadBody(&raw) != nil {
+			t.Fatalf("%s: read error: %v", typ, err)
+		}
+		fwd := new(pipe)
+		if err := f(fwd).Write(&h, raw); err != nil {
+			t.Fatalf("%s: forward error: %v", typ, err)
+		}
+		var got []string
+		fr := f(fwd)
+		if err := fr.ReadHeader(&h); err != nil || fr.ReadBody(&got) != nil || h.Seq != 2 || len(got) != 2 || got[1] != "b" {
+			t.Fatalf("%s: unexpected forwarded message %+v %v (%v)", typ, h, got, err)
+		}
+
+		// a body left unread is skipped by the next header
+		if err := r.ReadHeader(&h); err != nil || h.Seq != 3 {
+			t.Fatalf("%s: unexpected header %+v (%v)", typ, h, err)
+		}
+		_ = w.Write(&Header{Seq: 4}, 0)
+		if err := r.ReadHeader(&h); err != nil || h.Seq != 4 {
+			t.Fatalf("%s: expect the unread body to be skipped, got %+v (%v)", typ, h, err)
+		}
+		_ = r.ReadBody(nil)
+
+		conn.WriteString("\x02" + strings.Repeat("\x00", frameHeaderSize-1))
+		if err := r.ReadHeader(&h); !errors.Is(err, ErrFrameVersion) {
+			t.Fatalf("%s: expect ErrFrameVersion, got %v", typ, err)
+		}
+	}
+}
+
+func TestGobMarshaler_HeaderStream(t *testing.T) {
+	conn := new(pipe)
+	w, r := NewGobCodec(conn), NewGobCodec(conn)
+
+	// the first header carries the definition of Header and is too large,
+	// so the next one must start the stream over
+	w.(SizeLimiter).SetMaxSize(64, 0)
+	if err := w.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, 0); err != ErrHeaderTooLarge {
+		t.Fatal("expect ErrHeaderTooLarge, got", err)
+	}
+	w.(SizeLimiter).SetMaxSize(0, 0)
+	var sizes []int
+	for seq := uint64(2); seq <= 4; seq++ {
+		n := conn.Len()
+		_ = w.Write(&Header{ServiceMethod: "Foo.Sum", Seq: seq}, 0)
+		sizes = append(sizes, conn.Len()-n)
+	}
+	if sizes[1] != sizes[2] || sizes[1] >= sizes[0]/4 {
+		t.Fatalf("expect later headers not to repeat the type definitions, got frame sizes %v", sizes)
+	}
+	for seq := uint64(2); seq <= 4; seq++ {
+		var h Header
+		if err := r.ReadHeader(&h); err != nil || h.Seq != seq {
+			t.Fatalf("unexpected header %+v (%v)", h, err)
+		}
+	}
+}
+
+func TestFrameCodec_CorruptLength(t *testing.T) {
+	for typ, f := range NewCodecFuncMap {
+		conn := new(pipe)
+		conn.WriteString("\x01\x00\xff\xff\xff\xff" + strings.Repeat("\x00", frameHeaderSize-6))
+		var h Header
+		if err := f(conn).ReadHeader(&h); err != ErrFrameLength {
+			t.Fatalf("%s: expect ErrFrameLength, got %v", typ, err)
+		}
+	}
+}
@@ -1,63 +1,70 @@
 package codec
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/gob"
 	"io"
-	"log"
 )
 
-type GobCodec struct {
-	conn io.ReadWriteCloser
-	buf  *bufio.Writer
-	dec  *gob.Decoder
-	enc  *gob.Encoder
+// GobMarshaler encodes the headers of a connection as one gob stream, so that
+// the definition of Header is only sent with the first header: a header like
+// {ServiceMethod: "Foo.Sum", Seq: 7} takes 172 bytes on its own but 15 bytes
+// in the stream. Bodies are encoded each with a new encoder, so that they can
+// be skipped or forwarded without decoding. Bodies of basic types carry no
+// type definition, those of struct types carry theirs every time, e.g. 45
+// bytes instead of 8 for a struct of two ints.
+// A GobMarshaler serves a single connection.
+type GobMarshaler struct {
+	encBuf bytes.Buffer
+	enc    *gob.Encoder
+	decBuf bytes.Buffer
+	dec    *gob.Decoder
 }
 
-var _ Codec = (*GobCodec)(nil)
+var _ HeaderStream = (*GobMarshaler)(nil)
 
-func NewGobCodec(conn io.ReadWriteCloser) Codec {
-	buf := bufio.NewWriter(conn)
-	return &GobCodec{
-		conn: conn,
-		buf:  buf,
-		dec:  gob.NewDecoder(conn),
-		enc:  gob.NewEncoder(buf),
-	}
+// NewGobMarshaler returns a GobMarshaler for a new connection.
+func NewGobMarshaler() *GobMarshaler {
+	m := &GobMarshaler{}
+	m.ResetEncoder()
+	m.ResetDecoder()
+	return m
 }
 
-func (c *GobCodec) Close() error {
-	return c.conn.Close()
+func (m *GobMarshaler) ResetEncoder() {
+	m.encBuf.Reset()
+	m.enc = gob.NewEncoder(&m.encBuf)
 }
 
-// ReadHeader 将 header 从 conn 读取到 h 变量
-func (c *GobCodec) ReadHeader(h *Header) error {
-	return c.dec.Decode(h)
+func (m *GobMarshaler) ResetDecoder() {
+	m.decBuf.Reset()
+	m.dec = gob.NewDecoder(&m.decBuf)
 }
 
-// ReadBody 将 body 从 conn 读取到 body 变量
-func (c *GobCodec) ReadBody(body interface{}) error {
-	return c.dec.Decode(body)
-}
-
-// Write 将 header 和 body 写入到 buf
-func (c *GobCodec) Write(h *Header, body interface{}) (err error) {
-	defer func() {
-		err = c.buf.Flush()
-		if err != nil {
-			_ = c.Close()
+func (m *GobMarshaler) Marshal(v interface{}) ([]byte, error) {
+	if h, ok := v.(*Header); ok {
+		m.encBuf.Reset()
+		if err := m.enc.Encode(h); err != nil {
+			return nil, err
 		}
-	}()
-
-	if err = c.enc.Encode(h); err != nil {
-		log.Println("rpc codec: gob error encoding header: ", err)
-		return
+		return append([]byte(nil), m.encBuf.Bytes()...), nil
 	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
 
-	if err = c.enc.Encode(body); err != nil {
-		log.Println("rpc codec: gob error encoding body: ", err)
-		return
+func (m *GobMarshaler) Unmarshal(data []byte, v interface{}) error {
+	if h, ok := v.(*Header); ok {
+		m.decBuf.Reset()
+		m.decBuf.Write(data)
+		return m.dec.Decode(h)
 	}
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
 
-	return
+func NewGobCodec(conn io.ReadWriteCloser) Codec {
+	return NewFrameCodec(conn, NewGobMarshaler())
 }
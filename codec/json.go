@@ -0,0 +1,23 @@
+package codec
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// JsonMarshaler encodes headers and bodies as JSON.
+type JsonMarshaler struct{}
+
+var _ Marshaler = JsonMarshaler{}
+
+func (JsonMarshaler) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JsonMarshaler) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func NewJsonCodec(conn io.ReadWriteCloser) Codec {
+	return NewFrameCodec(conn, JsonMarshaler{})
+}
@@ -0,0 +1,19 @@
+package codec
+
+import "vrpc/status"
+
+var (
+	ErrHeaderTooLarge = status.New(status.ResourceExhausted, "rpc codec: header exceeds the maximum size")
+	ErrBodyTooLarge   = status.New(status.ResourceExhausted, "rpc codec: body exceeds the maximum size")
+)
+
+// SizeLimiter is implemented by codecs able to bound the size of the messages
+// they read and write, in bytes. 0 means no limit.
+//
+// Write fails with ErrHeaderTooLarge or ErrBodyTooLarge without writing
+// anything if a message is too large. ReadHeader and ReadBody fail with them
+// too, skipping the part of the message that is too large so that the next
+// message can be read.
+type SizeLimiter interface {
+	SetMaxSize(header, body int)
+}
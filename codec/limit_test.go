@@ -0,0 +1,67 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// pipe is an in-memory connection, what is written can be read back
+type pipe struct{ bytes.Buffer }
+
+func (p *pipe) Close() error { return nil }
+
+func TestCodec_MaxSize(t *testing.T) {
+	for typ, f := range NewCodecFuncMap {
+		conn := new(pipe)
+		w, r := f(conn), f(conn)
+		r.(SizeLimiter).SetMaxSize(0, 256)
+
+		big := strings.Repeat("x", 1024)
+		for _, body := range []string{"small", big, "after"} {
+			if err := w.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, body); err != nil {
+				t.Fatalf("%s: write error: %v", typ, err)
+			}
+		}
+
+		var h Header
+		var body string
+		if err := r.ReadHeader(&h); err != nil || r.ReadBody(&body) != nil || body != "small" {
+			t.Fatalf("%s: expect the small body, got %q (%v)", typ, body, err)
+		}
+		if err := r.ReadHeader(&h); err != nil {
+			t.Fatalf("%s: read header error: %v", typ, err)
+		}
+		if err := r.ReadBody(&body); err != ErrBodyTooLarge {
+			t.Fatalf("%s: expect ErrBodyTooLarge, got %v", typ, err)
+		}
+		if err := r.ReadHeader(&h); err != nil || r.ReadBody(&body) != nil || body != "after" {
+			t.Fatalf("%s: expect the stream to stay in sync, got %q (%v)", typ, body, err)
+		}
+
+		// an oversized header is skipped too, leaving its Seq to answer it
+		r.(SizeLimiter).SetMaxSize(200, 256)
+		_ = w.Write(&Header{ServiceMethod: strings.Repeat("x", 300), Seq: 5}, "skipped")
+		_ = w.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 6}, "after")
+		h = Header{}
+		if err := r.ReadHeader(&h); err != ErrHeaderTooLarge || h.Seq != 5 || h.ServiceMethod != "" {
+			t.Fatalf("%s: expect ErrHeaderTooLarge for seq 5, got %+v (%v)", typ, h, err)
+		}
+		if err := r.ReadHeader(&h); err != nil || h.Seq != 6 || r.ReadBody(&body) != nil || body != "after" {
+			t.Fatalf("%s: expect the stream to stay in sync, got %+v %q (%v)", typ, h, body, err)
+		}
+
+		w.(SizeLimiter).SetMaxSize(0, 256)
+		n := conn.Len()
+		if err := w.Write(&Header{Seq: 2}, big); err != ErrBodyTooLarge || conn.Len() != n {
+			t.Fatalf("%s: expect an oversized body not to be written, got %v", typ, err)
+		}
+		w.(SizeLimiter).SetMaxSize(8, 0)
+		if err := w.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 2}, "small"); err != ErrHeaderTooLarge || conn.Len() != n {
+			t.Fatalf("%s: expect an oversized header not to be written, got %v", typ, err)
+		}
+		_, _ = io.Copy(ioutil.Discard, conn)
+	}
+}
@@ -1,18 +1,81 @@
 package codec
 
-import "time"
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"time"
+)
 
 const MagicNumber = 0x3bef5c
 
+// Option 在连接建立时由客户端以 JSON 编码发送, 服务端校验后以 JSON 编码返回协商后的 Option, 之后才开始使用 Codec.
 type Option struct {
-	MagicNumber    int           // MagicNumber marks this's a geerpc request
-	CodecType      Type          // client may choose different Codec to encode body
-	ConnectTimeout time.Duration // 0 means no limit
-	HandleTimeout  time.Duration
+	MagicNumber       int           // MagicNumber marks this's a geerpc request
+	CodecType         Type          // client may choose different Codec to encode body
+	ConnectTimeout    time.Duration // 0 means no limit
+	HandleTimeout     time.Duration
+	HeartbeatInterval time.Duration // 客户端发送 ping 的间隔, 0 表示不发送心跳, 由握手协商
+	MaxHeaderSize     int           // header 的最大字节数, 0 表示不限制, 由握手协商, 两端都遵守
+	MaxBodySize       int           // body 的最大字节数, 0 表示不限制, 由握手协商, 两端都遵守
+	Compression       string        // 压缩 body 的算法, 例如 "gzip", 空表示不压缩; 服务端不支持时协商结果为空
+	CompressThreshold int           // 小于该字节数的 body 不压缩, 0 表示 DefaultCompressThreshold
+	TLSConfig         *tls.Config   `json:"-"` // XDial 连接 tls@addr 时使用的配置, 不参与握手
+
+	Credentials         *Credentials                 `json:",omitempty"` // 握手时提交给服务端认证的信息, 不会出现在握手响应中
+	CredentialsProvider func() (*Credentials, error) `json:"-"`          // 不为 nil 时每次握手前调用, 生成 Credentials, 例如带一次性 nonce 的签名
+	Error               string                       `json:",omitempty"` // 服务端拒绝连接的原因, 仅出现在握手响应中
+	ErrorCode           uint32                       `json:",omitempty"` // Error 的错误码, 见 vrpc/status
+}
+
+// Credentials 客户端在握手时提交的认证信息, 具体含义由服务端的 Authenticator 决定
+type Credentials struct {
+	Scheme string            // 认证方式, 例如 "bearer", "hmac"
+	Values map[string]string // 认证方式需要的参数
 }
 
+// MissedHeartbeats 连续这么多个心跳间隔内没有收到任何消息时, 认为连接已经断开并关闭连接
+const MissedHeartbeats = 3
+
 var DefaultOption = &Option{
 	MagicNumber:    MagicNumber,
 	CodecType:      GobType,
 	ConnectTimeout: time.Second * 10,
 }
+
+// Configure applies the negotiated opt to cc, as far as cc supports it.
+func Configure(cc Codec, opt *Option) {
+	if l, ok := cc.(SizeLimiter); ok {
+		l.SetMaxSize(opt.MaxHeaderSize, opt.MaxBodySize)
+	}
+	if s, ok := cc.(CompressionSetter); ok && opt.Compression != "" {
+		s.SetCompression(GetCompressor(opt.Compression), opt.CompressThreshold)
+	}
+}
+
+// bufferedConn 先读出握手时 json.Decoder 预读的数据, 再继续读 conn
+type bufferedConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// WriteOption 将 JSON 编码的 Option 写入 conn
+func WriteOption(conn io.Writer, opt *Option) error {
+	return json.NewEncoder(conn).Encode(opt)
+}
+
+// ReadOption 从 conn 读取 JSON 编码的 Option.
+// json.Decoder 可能预读了 Option 之后的数据, 因此返回的 conn 会先重放这部分数据, 后续的 Codec 必须使用它.
+func ReadOption(conn io.ReadWriteCloser) (*Option, io.ReadWriteCloser, error) {
+	var opt Option
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&opt); err != nil {
+		return nil, conn, err
+	}
+	// json.Encoder 在 Option 之后追加了一个换行符, 只去掉它: 之后的 Codec 数据可能以空白字节开头
+	buffered, _ := ioutil.ReadAll(dec.Buffered())
+	buffered = bytes.TrimPrefix(buffered, []byte("\n"))
+	return &opt, &bufferedConn{Reader: io.MultiReader(bytes.NewReader(buffered), conn), WriteCloser: conn}, nil
+}
@@ -0,0 +1,26 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+type rwc struct{ *bytes.Buffer }
+
+func (rwc) Close() error { return nil }
+
+func TestReadOption(t *testing.T) {
+	conn := rwc{new(bytes.Buffer)}
+	_ = json.NewEncoder(conn).Encode(DefaultOption)
+	conn.WriteString("\n\t codec data")
+
+	opt, r, err := ReadOption(conn)
+	if err != nil || opt.MagicNumber != MagicNumber {
+		t.Fatalf("unexpected option %+v (%v)", opt, err)
+	}
+	if data, _ := ioutil.ReadAll(r); string(data) != "\n\t codec data" {
+		t.Fatalf("expect only the newline ending the option to be dropped, got %q", data)
+	}
+}
@@ -0,0 +1,242 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// snappyCompressor compresses bodies in the snappy block format, a fast LZ77
+// trading ratio for speed: use it for large bodies on fast networks, gzip
+// when bandwidth is scarce. It is compatible with the block format of other
+// snappy implementations, not with their framing format.
+type snappyCompressor struct {
+	writers sync.Pool
+}
+
+func (c *snappyCompressor) Name() string { return "snappy" }
+
+func (c *snappyCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	sw, ok := c.writers.Get().(*snappyWriter)
+	if !ok {
+		sw = &snappyWriter{}
+	}
+	sw.w, sw.buf = w, sw.buf[:0]
+	return &pooledWriter{WriteCloser: sw, put: func() { c.writers.Put(sw) }}, nil
+}
+
+func (c *snappyCompressor) Decompress(r io.Reader) (io.ReadCloser, error) {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	n, l := binary.Uvarint(src)
+	if l <= 0 || n > 0xffffffff {
+		return nil, errSnappyCorrupt
+	}
+	return &snappyReader{src: src[l:], n: int(n)}, nil
+}
+
+var errSnappyCorrupt = errors.New("rpc codec: corrupt snappy body")
+
+// snappy 块格式: 解压后长度的 varint, 之后是一串元素, 每个元素的第一个字节的低 2 位是 tag:
+// literal 直接给出数据, copy 复制已解压数据中 offset 字节之前的 length 个字节.
+const (
+	snappyTagLiteral = 0x00
+	snappyTagCopy1   = 0x01 // length 4..11, offset < 2048
+	snappyTagCopy2   = 0x02 // length 1..64, offset < 65536
+	snappyTagCopy4   = 0x03 // length 1..64, 仅用于解压
+
+	snappyMaxOffset = 1<<16 - 1
+	snappyTableBits = 14
+)
+
+// snappyWriter buffers the body, which is compressed as one block on Close.
+type snappyWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (w *snappyWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+func (w *snappyWriter) Close() error {
+	_, err := w.w.Write(snappyEncode(w.buf))
+	return err
+}
+
+func snappyLoad32(b []byte, i int) uint32 {
+	return binary.LittleEndian.Uint32(b[i:])
+}
+
+func snappyHash(u uint32) uint32 {
+	return (u * 0x1e35a7bd) >> (32 - snappyTableBits)
+}
+
+// snappyEncode compresses src greedily: every 4 bytes already seen within
+// snappyMaxOffset start a match, the bytes in between are literals.
+func snappyEncode(src []byte) []byte {
+	var lenBuf [binary.MaxVarintLen64]byte
+	dst := append(make([]byte, 0, len(src)/2+16), lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(src)))]...)
+
+	var table [1 << snappyTableBits]int32 // 4 字节的 hash 到其位置 + 1, 0 表示没有
+	lit := 0                              // 尚未输出的 literal 的开始位置
+	for s := 0; s+4 <= len(src); {
+		u := snappyLoad32(src, s)
+		h := snappyHash(u)
+		candidate := int(table[h]) - 1
+		table[h] = int32(s + 1)
+		if candidate < 0 || s-candidate > snappyMaxOffset || snappyLoad32(src, candidate) != u {
+			s += 1 + (s-lit)>>5 // 越久没有匹配, 跳得越快
+			continue
+		}
+		n := 4
+		for s+n < len(src) && src[candidate+n] == src[s+n] {
+			n++
+		}
+		if lit < s {
+			dst = snappyEmitLiteral(dst, src[lit:s])
+		}
+		dst = snappyEmitCopy(dst, s-candidate, n)
+		s += n
+		lit = s
+	}
+	if lit < len(src) {
+		dst = snappyEmitLiteral(dst, src[lit:])
+	}
+	return dst
+}
+
+func snappyEmitLiteral(dst, lit []byte) []byte {
+	n := len(lit) - 1
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|snappyTagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|snappyTagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|snappyTagLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, lit...)
+}
+
+// snappyEmitCopy emits a match of length >= 4 at offset <= snappyMaxOffset.
+func snappyEmitCopy(dst []byte, offset, length int) []byte {
+	for length >= 68 {
+		dst = append(dst, 63<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		length -= 64
+	}
+	if length > 64 {
+		// 留下至少 4 个字节, 以便最后一个 copy 可以使用 copy1
+		dst = append(dst, 59<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		length -= 60
+	}
+	if length >= 12 || offset >= 2048 {
+		return append(dst, byte(length-1)<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+	}
+	return append(dst, byte(offset>>8)<<5|byte(length-4)<<2|snappyTagCopy1, byte(offset))
+}
+
+// snappyReader decodes a block as it is read, so that a reader giving up
+// after enough bytes doesn't pay for the whole body, however large the
+// declared length.
+type snappyReader struct {
+	src []byte // elements not decoded yet
+	dst []byte // decoded so far, copies refer to it
+	off int    // dst[:off] has been read
+	n   int    // declared decoded length
+	err error
+}
+
+func (r *snappyReader) Read(p []byte) (int, error) {
+	for r.off == len(r.dst) {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.step()
+	}
+	n := copy(p, r.dst[r.off:])
+	r.off += n
+	return n, nil
+}
+
+func (r *snappyReader) Close() error { return nil }
+
+// step decodes one element.
+func (r *snappyReader) step() {
+	src := r.src
+	if len(src) == 0 {
+		if len(r.dst) != r.n {
+			r.err = errSnappyCorrupt
+		} else {
+			r.err = io.EOF
+		}
+		return
+	}
+	switch tag := src[0]; tag & 0x03 {
+	case snappyTagLiteral:
+		x, s := int(tag>>2), 1
+		if x >= 60 {
+			s += x - 59
+			if len(src) < s {
+				r.err = errSnappyCorrupt
+				return
+			}
+			x = 0
+			for i := s - 1; i > 0; i-- {
+				x = x<<8 | int(src[i])
+			}
+		}
+		if length := x + 1; length <= len(src)-s {
+			r.dst = append(r.dst, src[s:s+length]...)
+			r.src = src[s+length:]
+		} else {
+			r.err = errSnappyCorrupt
+			return
+		}
+	case snappyTagCopy1:
+		if len(src) < 2 {
+			r.err = errSnappyCorrupt
+			return
+		}
+		r.src = src[2:]
+		r.copy(int(tag&0xe0)<<3|int(src[1]), 4+int(tag>>2)&0x07)
+	case snappyTagCopy2:
+		if len(src) < 3 {
+			r.err = errSnappyCorrupt
+			return
+		}
+		r.src = src[3:]
+		r.copy(int(binary.LittleEndian.Uint16(src[1:])), 1+int(tag>>2))
+	case snappyTagCopy4:
+		if len(src) < 5 {
+			r.err = errSnappyCorrupt
+			return
+		}
+		r.src = src[5:]
+		r.copy(int(binary.LittleEndian.Uint32(src[1:])), 1+int(tag>>2))
+	}
+	if r.err == nil && len(r.dst) > r.n {
+		r.err = errSnappyCorrupt
+	}
+}
+
+func (r *snappyReader) copy(offset, length int) {
+	if offset <= 0 || offset > len(r.dst) {
+		r.err = errSnappyCorrupt
+		return
+	}
+	// 重叠的 copy 会复制它自己刚刚产生的字节, 因此逐字节复制
+	start := len(r.dst) - offset
+	for i := 0; i < length; i++ {
+		r.dst = append(r.dst, r.dst[start+i])
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net"
@@ -11,7 +10,9 @@ import (
 	"time"
 	"vrpc/client"
 	"vrpc/codec"
+	"vrpc/registry"
 	"vrpc/server"
+	"vrpc/xclient"
 )
 
 func startServer(addr chan string) {
@@ -40,8 +41,10 @@ func day1() {
 
 	time.Sleep(time.Second)
 	// send options
-	_ = json.NewEncoder(conn).Encode(codec.DefaultOption)
-	cc := codec.NewGobCodec(conn)
+	_ = codec.WriteOption(conn, codec.DefaultOption)
+	// the server replies with the negotiated options before any request
+	_, rwc, _ := codec.ReadOption(conn)
+	cc := codec.NewGobCodec(rwc)
 	// send request & receive response
 	for i := 0; i < 5; i++ {
 		h := &codec.Header{
@@ -77,7 +80,8 @@ func day2() {
 			defer wg.Done()
 			args := fmt.Sprintf("geerpc req %d", i)
 			var reply string
-			ctx, _ := context.WithTimeout(context.Background(), time.Second)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
 			err = cli.Call(ctx, "Foo.Sum", args, &reply)
 			if err != nil {
 				log.Fatal("call error: ", err)
@@ -120,7 +124,8 @@ func day3() {
 			defer wg.Done()
 			args := Args{Num1: i, Num2: i * i}
 			var reply int
-			ctx, _ := context.WithTimeout(context.Background(), time.Second)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
 			err = cli.Call(ctx, "Foo.Sum", args, &reply)
 			if err != nil {
 				log.Fatal("call error:", err)
@@ -137,19 +142,24 @@ func day3() {
 	}
 }
 
-func startServer5(addr chan string) {
+func startServer5(registryCh chan string) {
 	var foo Foo
 	_ = server.Register(&foo)
 	server.HandleHTTP()
+	registry.HandleHTTP()
 
-	l, _ := net.Listen("tcp", ":9999")
-	addr <- l.Addr().String()
+	// the registry and the rpc server share the same http server
+	l, _ := net.Listen("tcp", ":0")
+	registryAddr := "http://" + l.Addr().String() + registry.DefaultPath
+	server.Heartbeat(registryAddr, "http@"+l.Addr().String(), 0)
+	registryCh <- registryAddr
 	_ = http.Serve(l, nil)
 }
 
-func call5(addrCh chan string) {
-	client, _ := client.DialHTTP("tcp", <-addrCh)
-	defer func() { _ = client.Close() }()
+func call5(registryCh chan string) {
+	d := xclient.NewRegistryDiscovery(<-registryCh, 0)
+	xc := xclient.NewXClient(d, xclient.RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
 
 	time.Sleep(time.Second)
 	// send request & receive response
@@ -160,8 +170,9 @@ func call5(addrCh chan string) {
 			defer wg.Done()
 			args := Args{Num1: i, Num2: i * i}
 			var reply int
-			ctx, _ := context.WithTimeout(context.Background(), time.Second)
-			if err := client.Call(ctx, "Foo.Sum", args, &reply); err != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := xc.Call(ctx, "Foo.Sum", args, &reply); err != nil {
 				log.Fatal("call Foo.Sum error:", err)
 			}
 			log.Printf("%d + %d = %d", args.Num1, args.Num2, reply)
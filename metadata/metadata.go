@@ -0,0 +1,96 @@
+// Package metadata carries key/value pairs such as trace IDs, auth tokens
+// or tenant IDs alongside a call, in the header of requests and responses.
+package metadata
+
+import (
+	"context"
+	"strings"
+)
+
+// MD is a mapping from metadata keys to values.
+// Keys are case-insensitive and stored in lower case.
+type MD map[string]string
+
+// New creates an MD from a given key-value map.
+func New(m map[string]string) MD {
+	md := make(MD, len(m))
+	for k, v := range m {
+		md.Set(k, v)
+	}
+	return md
+}
+
+// Pairs returns an MD formed by the mapping of key, value ...
+// Pairs panics if len(kv) is odd.
+func Pairs(kv ...string) MD {
+	if len(kv)%2 == 1 {
+		panic("metadata: Pairs got an odd number of input pairs")
+	}
+	md := make(MD, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		md.Set(kv[i], kv[i+1])
+	}
+	return md
+}
+
+// Get returns the value of key, or "" if it is absent.
+func (md MD) Get(key string) string {
+	return md[strings.ToLower(key)]
+}
+
+// Set sets the value of key, replacing any existing value.
+func (md MD) Set(key, value string) {
+	md[strings.ToLower(key)] = value
+}
+
+// Copy returns a copy of md.
+func (md MD) Copy() MD {
+	return Join(md)
+}
+
+// Join joins any number of mds into a single MD, later values win.
+func Join(mds ...MD) MD {
+	out := MD{}
+	for _, md := range mds {
+		for k, v := range md {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+type outgoingKey struct{}
+type incomingKey struct{}
+
+// NewOutgoingContext creates a new context with outgoing md attached,
+// the client sends it in the header of every call made with the context.
+func NewOutgoingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, outgoingKey{}, md)
+}
+
+// AppendToOutgoingContext returns a new context with the provided kv merged
+// with any existing outgoing metadata in the context.
+func AppendToOutgoingContext(ctx context.Context, kv ...string) context.Context {
+	md, _ := FromOutgoingContext(ctx)
+	return NewOutgoingContext(ctx, Join(md, Pairs(kv...)))
+}
+
+// FromOutgoingContext returns the outgoing metadata in ctx if it exists.
+// The returned MD must not be modified.
+func FromOutgoingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(outgoingKey{}).(MD)
+	return md, ok
+}
+
+// NewIncomingContext creates a new context with incoming md attached,
+// the server uses it to hand the metadata of a request to its handler.
+func NewIncomingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, incomingKey{}, md)
+}
+
+// FromIncomingContext returns the incoming metadata in ctx if it exists.
+// The returned MD must not be modified.
+func FromIncomingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(incomingKey{}).(MD)
+	return md, ok
+}
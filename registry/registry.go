@@ -0,0 +1,151 @@
+package registry
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry is a simple register center, provide following functions.
+// add a server and receive heartbeat to keep it alive.
+// returns all alive servers and delete dead servers sync simultaneously.
+type Registry struct {
+	timeout time.Duration
+	mu      sync.Mutex // protect following
+	servers map[string]*ServerItem
+}
+
+type ServerItem struct {
+	Addr  string
+	start time.Time // time of the last heartbeat
+}
+
+const (
+	DefaultPath    = "/_geerpc_/registry"
+	DefaultTimeout = time.Minute * 5
+
+	serverHeader  = "X-Geerpc-Server"  // POST: the rpcAddr of the server sending heartbeat
+	serversHeader = "X-Geerpc-Servers" // GET: comma separated rpcAddr of alive servers
+)
+
+// New create a registry instance with timeout setting,
+// a server is removed if no heartbeat is received within timeout, 0 means never
+func New(timeout time.Duration) *Registry {
+	return &Registry{
+		servers: make(map[string]*ServerItem),
+		timeout: timeout,
+	}
+}
+
+var DefaultRegistry = New(DefaultTimeout)
+
+func (r *Registry) putServer(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.servers[addr]
+	if s == nil {
+		r.servers[addr] = &ServerItem{Addr: addr, start: time.Now()}
+	} else {
+		s.start = time.Now() // if exists, update start time to keep alive
+	}
+}
+
+func (r *Registry) aliveServers() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var alive []string
+	for addr, s := range r.servers {
+		if r.timeout == 0 || s.start.Add(r.timeout).After(time.Now()) {
+			alive = append(alive, addr)
+		} else {
+			delete(r.servers, addr)
+		}
+	}
+	sort.Strings(alive)
+	return alive
+}
+
+// ServeHTTP runs at DefaultPath.
+// GET lists alive servers in X-Geerpc-Servers,
+// POST records a heartbeat of the server in X-Geerpc-Server.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+		w.Header().Set(serversHeader, strings.Join(r.aliveServers(), ","))
+	case "POST":
+		// keep it simple, server is in req.Header
+		addr := req.Header.Get(serverHeader)
+		if addr == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		r.putServer(addr)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleHTTP registers an HTTP handler for Registry messages on registryPath
+func (r *Registry) HandleHTTP(registryPath string) {
+	http.Handle(registryPath, r)
+	log.Println("rpc registry path:", registryPath)
+}
+
+// HandleHTTP is a convenient approach for DefaultRegistry to register HTTP handlers
+func HandleHTTP() {
+	DefaultRegistry.HandleHTTP(DefaultPath)
+}
+
+// DefaultRequestTimeout bounds SendHeartbeat and GetServers
+const DefaultRequestTimeout = time.Second * 10
+
+// SendHeartbeat tells the registry that the server at addr is alive
+func SendHeartbeat(registry, addr string) error {
+	return SendHeartbeatTimeout(registry, addr, DefaultRequestTimeout)
+}
+
+// SendHeartbeatTimeout is SendHeartbeat giving up after timeout, 0 means no limit
+func SendHeartbeatTimeout(registry, addr string, timeout time.Duration) error {
+	httpClient := &http.Client{Timeout: timeout}
+	req, _ := http.NewRequest("POST", registry, nil)
+	req.Header.Set(serverHeader, addr)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("rpc registry: heartbeat rejected: " + resp.Status)
+	}
+	return nil
+}
+
+// GetServers fetches the alive servers from the registry
+func GetServers(registry string) ([]string, error) {
+	return GetServersTimeout(registry, DefaultRequestTimeout)
+}
+
+// GetServersTimeout is GetServers giving up after timeout, 0 means no limit
+func GetServersTimeout(registry string, timeout time.Duration) ([]string, error) {
+	httpClient := &http.Client{Timeout: timeout}
+	resp, err := httpClient.Get(registry)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("rpc registry: refresh failed: " + resp.Status)
+	}
+
+	var servers []string
+	for _, server := range strings.Split(resp.Header.Get(serversHeader), ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			servers = append(servers, server)
+		}
+	}
+	return servers, nil
+}
@@ -0,0 +1,41 @@
+package registry
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRegistry_Heartbeat(t *testing.T) {
+	r := New(time.Millisecond * 200)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	if err := SendHeartbeat(ts.URL, "tcp@127.0.0.1:1"); err != nil {
+		t.Fatal("heartbeat error:", err)
+	}
+	if err := SendHeartbeat(ts.URL, "tcp@127.0.0.1:2"); err != nil {
+		t.Fatal("heartbeat error:", err)
+	}
+	servers, err := GetServers(ts.URL)
+	if err != nil || len(servers) != 2 {
+		t.Fatalf("expect 2 alive servers, got %v (%v)", servers, err)
+	}
+
+	time.Sleep(time.Millisecond * 150)
+	_ = SendHeartbeat(ts.URL, "tcp@127.0.0.1:2")
+	time.Sleep(time.Millisecond * 100)
+	servers, _ = GetServers(ts.URL)
+	if len(servers) != 1 || servers[0] != "tcp@127.0.0.1:2" {
+		t.Fatal("expect the server without heartbeat to be dropped, got", servers)
+	}
+}
+
+func TestRegistry_BadHeartbeat(t *testing.T) {
+	ts := httptest.NewServer(New(0))
+	defer ts.Close()
+
+	if err := SendHeartbeat(ts.URL, ""); err == nil {
+		t.Fatal("expect heartbeat without address to be rejected")
+	}
+}
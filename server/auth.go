@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"vrpc/auth"
+	"vrpc/codec"
+)
+
+// SetAuthenticator makes the server check the credentials sent in the Option
+// handshake with a. A connection a rejects is refused with the reason in the
+// Option reply, the principal of an accepted one is available to every request
+// it serves through auth.FromContext.
+// With no authenticator every connection is accepted.
+func (server *Server) SetAuthenticator(a auth.Authenticator) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.authenticator = a
+}
+
+// authenticate checks the credentials in opt, returning the context of the
+// connection carrying the principal.
+func (server *Server) authenticate(ctx context.Context, opt *codec.Option) (context.Context, error) {
+	server.mu.Lock()
+	a := server.authenticator
+	server.mu.Unlock()
+	if a == nil {
+		return ctx, nil
+	}
+	p, err := a.Authenticate(ctx, opt.Credentials)
+	if err != nil {
+		return ctx, err
+	}
+	return auth.NewContext(ctx, p), nil
+}
+
+// Authorize returns an interceptor checking every call against policy with
+// the principal of its connection before the service method runs.
+// Denied calls fail with an error wrapping auth.ErrPermissionDenied.
+func Authorize(policy *auth.Policy) Interceptor {
+	return func(ctx context.Context, inv *Invocation, next Handler) error {
+		p, _ := auth.FromContext(ctx)
+		if err := policy.Check(p, inv.ServiceMethod); err != nil {
+			return fmt.Errorf("rpc server: %w to call %s", err, inv.ServiceMethod)
+		}
+		return next(ctx, inv)
+	}
+}
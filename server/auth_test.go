@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"vrpc/auth"
+	"vrpc/client"
+	"vrpc/codec"
+	"vrpc/status"
+)
+
+type Who int
+
+func (w Who) Ami(ctx context.Context, _ int, reply *string) error {
+	if p, ok := auth.FromContext(ctx); ok {
+		*reply = p.Name
+	}
+	return nil
+}
+
+func (w Who) Flush(_ int, reply *bool) error {
+	*reply = true
+	return nil
+}
+
+func startWho(t *testing.T, a auth.Authenticator, interceptors ...Interceptor) string {
+	s := NewServer()
+	var w Who
+	_ = s.Register(&w)
+	s.SetAuthenticator(a)
+	s.Use(interceptors...)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	go s.Accept(l)
+	return l.Addr().String()
+}
+
+func TestServer_Authenticator(t *testing.T) {
+	addr := startWho(t, auth.Chain(
+		auth.Bearer(map[string]*auth.Principal{"s3cret": {Name: "alice"}}),
+		auth.HMAC(map[string]auth.HMACKey{"k1": {Secret: []byte("shared"), Principal: &auth.Principal{Name: "bob"}}}, time.Minute),
+	))
+
+	whoami := func(opt *codec.Option) (string, error) {
+		c, err := client.Dial("tcp", addr, opt)
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = c.Close() }()
+		var reply string
+		err = c.Call(context.Background(), "Who.Ami", 0, &reply)
+		return reply, err
+	}
+
+	t.Run("bearer", func(t *testing.T) {
+		name, err := whoami(&codec.Option{Credentials: auth.BearerCredentials("s3cret")})
+		if err != nil || name != "alice" {
+			t.Fatalf("expect alice, got %q (%v)", name, err)
+		}
+	})
+	t.Run("hmac", func(t *testing.T) {
+		name, err := whoami(&codec.Option{CredentialsProvider: auth.HMACCredentials("k1", []byte("shared"))})
+		if err != nil || name != "bob" {
+			t.Fatalf("expect bob, got %q (%v)", name, err)
+		}
+	})
+	t.Run("rejected", func(t *testing.T) {
+		for _, opt := range []*codec.Option{
+			{},
+			{Credentials: auth.BearerCredentials("wrong")},
+			{CredentialsProvider: auth.HMACCredentials("k1", []byte("guess"))},
+		} {
+			_, err := whoami(opt)
+			if err == nil || !strings.Contains(err.Error(), "connection refused") {
+				t.Fatal("expect the connection to be refused, got", err)
+			}
+		}
+	})
+	t.Run("hmac replay", func(t *testing.T) {
+		creds, _ := auth.HMACCredentials("k1", []byte("shared"))()
+		if _, err := whoami(&codec.Option{Credentials: creds}); err != nil {
+			t.Fatal("first use of the nonce should succeed:", err)
+		}
+		if _, err := whoami(&codec.Option{Credentials: creds}); err == nil {
+			t.Fatal("expect a replayed nonce to be refused")
+		}
+	})
+}
+
+func TestServer_Authorize(t *testing.T) {
+	policy := auth.NewPolicy().
+		Allow("Who.Flush", nil, "admin").
+		Deny("Who.*", []string{"mallory"}).
+		Allow("Who.Ami", []string{"*"})
+	addr := startWho(t, auth.Bearer(map[string]*auth.Principal{
+		"a": {Name: "alice", Roles: []string{"admin"}},
+		"b": {Name: "bob"},
+		"m": {Name: "mallory"},
+	}), Authorize(policy))
+
+	call := func(token, method string) error {
+		c, err := client.Dial("tcp", addr, &codec.Option{Credentials: auth.BearerCredentials(token)})
+		if err != nil {
+			return err
+		}
+		defer func() { _ = c.Close() }()
+		var reply interface{}
+		switch method {
+		case "Who.Ami":
+			reply = new(string)
+		default:
+			reply = new(bool)
+		}
+		return c.Call(context.Background(), method, 0, reply)
+	}
+
+	for _, tc := range []struct {
+		token, method string
+		allowed       bool
+	}{
+		{"a", "Who.Flush", true},
+		{"a", "Who.Ami", true},
+		{"b", "Who.Ami", true},
+		{"b", "Who.Flush", false},
+		{"m", "Who.Ami", false},
+	} {
+		err := call(tc.token, tc.method)
+		if tc.allowed && err != nil {
+			t.Fatalf("%s: expect %s to be allowed, got %v", tc.token, tc.method, err)
+		}
+		if !tc.allowed && !errors.Is(err, status.PermissionDenied) {
+			t.Fatalf("%s: expect %s to be denied, got %v", tc.token, tc.method, err)
+		}
+	}
+
+	// calls matched by no rule follow the default, which may change while serving
+	policy.SetDefaultAllow(true)
+	if err := call("b", "Who.Flush"); err != nil {
+		t.Fatal("expect the default to allow unmatched calls, got", err)
+	}
+}
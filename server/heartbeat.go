@@ -0,0 +1,39 @@
+package server
+
+import (
+	"log"
+	"time"
+	"vrpc/registry"
+)
+
+// Heartbeat sends a heartbeat for rpcAddr to the registry every period in the background,
+// so that the registry keeps listing the server until it shuts down.
+// If period is 0, it defaults to one minute less than registry.DefaultTimeout.
+func (server *Server) Heartbeat(registryAddr, rpcAddr string, period time.Duration) {
+	if period == 0 {
+		// make sure there is enough time to send heart beat
+		// before it's removed from registry
+		period = registry.DefaultTimeout - time.Minute
+	}
+	done := server.getDoneChan()
+	go func() {
+		t := time.NewTicker(period)
+		defer t.Stop()
+		for {
+			// a heartbeat is useless once the next one is due
+			if err := registry.SendHeartbeatTimeout(registryAddr, rpcAddr, period); err != nil {
+				log.Println("rpc server: heart beat err:", err)
+			}
+			select {
+			case <-t.C:
+			case <-done: // stop heartbeats once the server shuts down
+				return
+			}
+		}
+	}()
+}
+
+// Heartbeat sends heartbeats of the DefaultServer to the registry in the background.
+func Heartbeat(registryAddr, rpcAddr string, period time.Duration) {
+	DefaultServer.Heartbeat(registryAddr, rpcAddr, period)
+}
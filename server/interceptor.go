@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"vrpc/codec"
+)
+
+// Invocation describes the service method call an interceptor wraps.
+type Invocation struct {
+	ServiceMethod string        // 格式: [服务名].[方法名]
+	Header        *codec.Header // a copy of the request header, including its metadata
+	Argv, Replyv  reflect.Value // argv and replyv of request
+}
+
+// Handler invokes the service method described by inv.
+type Handler func(ctx context.Context, inv *Invocation) error
+
+// Interceptor wraps the invocation of a service method.
+// It calls next to continue the chain, or returns an error without calling
+// next to short-circuit it; the returned error is sent back in Header.Error,
+// along with its code if it is a *status.Error.
+type Interceptor func(ctx context.Context, inv *Invocation, next Handler) error
+
+// Use appends interceptors to the chain run around every service method call,
+// interceptors run in registration order.
+func (server *Server) Use(interceptors ...Interceptor) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.interceptors = append(server.interceptors, interceptors...)
+}
+
+// chain wraps h with the registered interceptors, the first one being the outermost.
+func (server *Server) chain(h Handler) Handler {
+	server.mu.Lock()
+	interceptors := server.interceptors
+	server.mu.Unlock()
+
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], h
+		h = func(ctx context.Context, inv *Invocation) error {
+			return interceptor(ctx, inv, next)
+		}
+	}
+	return h
+}
+
+// Use appends interceptors to the chain of the DefaultServer.
+func Use(interceptors ...Interceptor) { DefaultServer.Use(interceptors...) }
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"vrpc/client"
+	"vrpc/metadata"
+)
+
+func TestServer_Use(t *testing.T) {
+	s := NewServer()
+	var m Meta
+	_ = s.Register(&m)
+
+	var mu sync.Mutex
+	var trace []string
+	record := func(name string) Interceptor {
+		return func(ctx context.Context, inv *Invocation, next Handler) error {
+			mu.Lock()
+			trace = append(trace, name+">"+inv.ServiceMethod)
+			mu.Unlock()
+			err := next(ctx, inv)
+			mu.Lock()
+			trace = append(trace, name+"<"+*inv.Replyv.Interface().(*string))
+			mu.Unlock()
+			return err
+		}
+	}
+	auth := func(ctx context.Context, inv *Invocation, next Handler) error {
+		if inv.Header.Metadata["token"] != "secret" {
+			return errors.New("rpc server: unauthenticated")
+		}
+		return next(ctx, inv)
+	}
+	s.Use(record("first"), record("second"))
+	s.Use(auth)
+
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go s.Accept(l)
+	c, err := client.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = c.Close() }()
+
+	var reply string
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("token", "secret"))
+	if err := c.Call(ctx, "Meta.Echo", "token", &reply); err != nil || reply != "secret" {
+		t.Fatalf("expect the call to pass the interceptors, got %q (%v)", reply, err)
+	}
+	want := "first>Meta.Echo second>Meta.Echo second<secret first<secret"
+	if got := strings.Join(trace, " "); got != want {
+		t.Fatalf("expect interceptors to run in registration order:\n got %s\nwant %s", got, want)
+	}
+
+	err = c.Call(context.Background(), "Meta.Echo", "token", &reply)
+	if err == nil || !strings.Contains(err.Error(), "unauthenticated") {
+		t.Fatal("expect the interceptor to short-circuit the call, got", err)
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+	"vrpc/codec"
+)
+
+// SetHeartbeatInterval sets the interval at which clients have to send pings.
+// During the handshake the smaller of it and the interval asked by the client
+// is agreed on; 0 leaves the choice to the client.
+// A connection on which nothing arrives for codec.MissedHeartbeats intervals is closed.
+func (server *Server) SetHeartbeatInterval(d time.Duration) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.heartbeatInterval = d
+}
+
+// negotiateHeartbeat returns the heartbeat interval agreed with a client asking for requested.
+func (server *Server) negotiateHeartbeat(requested time.Duration) time.Duration {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if requested <= 0 || (server.heartbeatInterval > 0 && server.heartbeatInterval < requested) {
+		return server.heartbeatInterval
+	}
+	return requested
+}
+
+// touch records that a message arrived on the connection.
+func (c *serverConn) touch() {
+	atomic.StoreInt64(&c.lastRecv, time.Now().UnixNano())
+}
+
+// watchHeartbeat closes the connection once nothing has arrived on it for
+// codec.MissedHeartbeats intervals, which ends serveCodec.
+func (c *serverConn) watchHeartbeat(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			lastRecv := time.Unix(0, atomic.LoadInt64(&c.lastRecv))
+			if time.Since(lastRecv) > interval*codec.MissedHeartbeats {
+				log.Println("rpc server: heartbeat timeout, close the connection")
+				_ = c.rwc.Close()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+	"vrpc/codec"
+)
+
+func TestServer_HeartbeatTimeout(t *testing.T) {
+	_, addr, _ := startSleeper(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// handshake asking for heartbeats, but never send any
+	opt := *codec.DefaultOption
+	opt.HeartbeatInterval = time.Millisecond * 30
+	_ = codec.WriteOption(conn, &opt)
+	negotiated, rwc, err := codec.ReadOption(conn)
+	if err != nil || negotiated.HeartbeatInterval != opt.HeartbeatInterval {
+		t.Fatal("expect the handshake to agree on the interval, got", negotiated, err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := rwc.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("expect the server to close the connection without pings, got", err)
+	}
+}
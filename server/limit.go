@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+	"vrpc/codec"
+	"vrpc/status"
+)
+
+// ConcurrencyLimits bounds the number of requests handled at the same time.
+// A limit of 0 means unlimited.
+//
+// A request over any of the limits waits for a slot if fewer than QueueSize
+// requests are waiting already, for up to QueueTimeout or until its caller
+// gives up. Otherwise, or once the wait times out, it fails with
+// ErrResourceExhausted. A request keeps its slots until its method returns,
+// even if the response was sent earlier because of a timeout.
+type ConcurrencyLimits struct {
+	Server       int            // requests of the whole server
+	Conn         int            // requests of a connection
+	Method       int            // requests of each method without an entry in Methods
+	Methods      map[string]int // requests of a method by "Service.Method"
+	QueueSize    int            // requests of the server waiting for a slot, 0 rejects right away
+	QueueTimeout time.Duration  // maximum wait for a slot, 0 means until the request context is done
+}
+
+// ErrResourceExhausted is sent back for requests over the concurrency limits.
+var ErrResourceExhausted = status.New(status.ResourceExhausted, "rpc server: too many requests")
+
+// semaphore bounds concurrency with its capacity, nil means unlimited.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+func (s semaphore) tryAcquire() bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s semaphore) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// limiter holds the semaphores of one set of ConcurrencyLimits.
+type limiter struct {
+	limits  ConcurrencyLimits
+	server  semaphore
+	methods map[string]semaphore // every registered method, created lazily
+	queued  int32                // requests waiting for a slot, accessed atomically
+}
+
+// SetMaxMessageSize sets the maximum size in bytes of the headers and bodies
+// exchanged with clients, 0 means no limit. During the handshake the smaller
+// of each and the size asked by the client is agreed on, and both sides
+// enforce it: messages over the limit fail with codec.ErrHeaderTooLarge or
+// codec.ErrBodyTooLarge.
+func (server *Server) SetMaxMessageSize(header, body int) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.maxHeaderSize, server.maxBodySize = header, body
+}
+
+// negotiateMaxSize returns the maximum message sizes agreed with a client asking for opt.
+func (server *Server) negotiateMaxSize(opt *codec.Option) (header, body int) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return minLimit(server.maxHeaderSize, opt.MaxHeaderSize), minLimit(server.maxBodySize, opt.MaxBodySize)
+}
+
+// minLimit returns the smaller of two limits, 0 meaning no limit.
+func minLimit(a, b int) int {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// SetConcurrencyLimits sets the limits of requests handled at the same time.
+// The new limits apply to connections and requests accepted from now on.
+func (server *Server) SetConcurrencyLimits(limits ConcurrencyLimits) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.limiter = &limiter{
+		limits:  limits,
+		server:  newSemaphore(limits.Server),
+		methods: make(map[string]semaphore),
+	}
+}
+
+func (server *Server) getLimiter() *limiter {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.limiter
+}
+
+// semaphores returns the semaphores a request for serviceMethod on c needs,
+// in the order they are acquired.
+func (server *Server) semaphores(c *serverConn, serviceMethod string) (*limiter, []semaphore) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	l := server.limiter
+	if l == nil {
+		return nil, nil
+	}
+	m, ok := l.methods[serviceMethod]
+	if !ok {
+		n, ok := l.limits.Methods[serviceMethod]
+		if !ok {
+			n = l.limits.Method
+		}
+		m = newSemaphore(n)
+		l.methods[serviceMethod] = m
+	}
+	// the narrowest first, so that a queued request holds no slot other methods could use
+	return l, []semaphore{m, c.sem, l.server}
+}
+
+func releaseAll(sems []semaphore) {
+	for _, s := range sems {
+		s.release()
+	}
+}
+
+// admit reserves the slots of req, waiting in the queue if allowed. It calls
+// handle with the function releasing the slots once they are all reserved,
+// and reject if the request can't be handled. Either is called from a new
+// goroutine unless the request is admitted or rejected right away.
+func (server *Server) admit(c *serverConn, req *request, handle func(release func()), reject func(err error)) {
+	l, sems := server.semaphores(c, req.h.ServiceMethod)
+	acquired := 0
+	for acquired < len(sems) && sems[acquired].tryAcquire() {
+		acquired++
+	}
+	if acquired == len(sems) {
+		handle(func() { releaseAll(sems) })
+		return
+	}
+	if int(atomic.AddInt32(&l.queued, 1)) > l.limits.QueueSize {
+		atomic.AddInt32(&l.queued, -1)
+		releaseAll(sems[:acquired])
+		reject(ErrResourceExhausted)
+		return
+	}
+
+	go func() {
+		ctx, cancel := req.ctx, context.CancelFunc(func() {})
+		if l.limits.QueueTimeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, l.limits.QueueTimeout)
+		}
+		defer cancel()
+		for ; acquired < len(sems); acquired++ {
+			if sems[acquired].acquire(ctx) != nil {
+				break
+			}
+		}
+		atomic.AddInt32(&l.queued, -1)
+		if acquired < len(sems) {
+			releaseAll(sems[:acquired])
+			reject(ErrResourceExhausted)
+			return
+		}
+		handle(func() { releaseAll(sems) })
+	}()
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"vrpc/client"
+	"vrpc/codec"
+	"vrpc/status"
+)
+
+func TestServer_ConcurrencyLimits(t *testing.T) {
+	s, addr, _ := startSleeper(t)
+	c, err := client.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = c.Close() }()
+
+	// sleep starts a call sleeping d and returns the channel it completes on
+	sleep := func(d time.Duration) chan *client.Call {
+		done := make(chan *client.Call, 1)
+		c.Go("Sleeper.Sleep", d, new(int), done)
+		return done
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		s.SetConcurrencyLimits(ConcurrencyLimits{Methods: map[string]int{"Sleeper.Sleep": 1}})
+		first := sleep(time.Millisecond * 200)
+		time.Sleep(time.Millisecond * 50)
+		if call := <-sleep(0); !errors.Is(call.Error, status.ResourceExhausted) {
+			t.Fatal("expect ResourceExhausted, got", call.Error)
+		}
+		var reply string
+		if err := c.Call(context.Background(), "Sleeper.Wait", time.Millisecond, &reply); err != nil {
+			t.Fatal("expect other methods to be unaffected, got", err)
+		}
+		if call := <-first; call.Error != nil {
+			t.Fatal("expect the first call to succeed, got", call.Error)
+		}
+	})
+	t.Run("queue", func(t *testing.T) {
+		s.SetConcurrencyLimits(ConcurrencyLimits{Server: 1, QueueSize: 1, QueueTimeout: time.Second})
+		first := sleep(time.Millisecond * 200)
+		time.Sleep(time.Millisecond * 50)
+		second, third := sleep(0), sleep(0)
+		if call := <-third; !errors.Is(call.Error, status.ResourceExhausted) {
+			t.Fatal("expect a full queue to reject, got", call.Error)
+		}
+		for _, done := range []chan *client.Call{first, second} {
+			if call := <-done; call.Error != nil {
+				t.Fatal("expect queued calls to succeed, got", call.Error)
+			}
+		}
+	})
+	t.Run("queue timeout", func(t *testing.T) {
+		s.SetConcurrencyLimits(ConcurrencyLimits{Server: 1, QueueSize: 1, QueueTimeout: time.Millisecond * 50})
+		first := sleep(time.Millisecond * 300)
+		time.Sleep(time.Millisecond * 50)
+		if call := <-sleep(0); !errors.Is(call.Error, status.ResourceExhausted) {
+			t.Fatal("expect the wait to time out, got", call.Error)
+		}
+		<-first
+	})
+}
+
+type Blob int
+
+func (b Blob) Repeat(n int, reply *string) error {
+	*reply = strings.Repeat("x", n)
+	return nil
+}
+
+func (b Blob) Len(s string, reply *int) error {
+	*reply = len(s)
+	return nil
+}
+
+func TestServer_MaxMessageSize(t *testing.T) {
+	s := NewServer()
+	var b Blob
+	_ = s.Register(&b)
+	s.SetMaxMessageSize(0, 512)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go s.Accept(l)
+
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+		c, err := client.Dial("tcp", l.Addr().String(), &codec.Option{CodecType: typ, MaxBodySize: 4096})
+		if err != nil {
+			t.Fatal("dial error:", err)
+		}
+		ctx := context.Background()
+		var n int
+		if err := c.Call(ctx, "Blob.Len", strings.Repeat("x", 1024), &n); !errors.Is(err, status.ResourceExhausted) {
+			t.Fatalf("%s: expect the negotiated limit to reject the argument, got %v", typ, err)
+		}
+		var reply string
+		if err := c.Call(ctx, "Blob.Repeat", 1024, &reply); !errors.Is(err, status.ResourceExhausted) {
+			t.Fatalf("%s: expect the reply to be rejected, got %v", typ, err)
+		}
+		if err := c.Call(ctx, "Blob.Repeat", 10, &reply); err != nil || len(reply) != 10 {
+			t.Fatalf("%s: expect the connection to stay usable, got %q (%v)", typ, reply, err)
+		}
+		_ = c.Close()
+	}
+}
+
+func TestServer_MaxHeaderSize(t *testing.T) {
+	s := NewServer()
+	var b Blob
+	var p Panicker
+	_ = s.Register(&b)
+	_ = s.Register(&p)
+	s.SetMaxMessageSize(512, 0)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go s.Accept(l)
+
+	t.Run("response", func(t *testing.T) {
+		for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+			c, err := client.Dial("tcp", l.Addr().String(), &codec.Option{CodecType: typ})
+			if err != nil {
+				t.Fatal("dial error:", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+			// the stack of the panic doesn't fit in the header
+			var n int
+			if err := c.Call(ctx, "Panicker.Panic", "boom", &n); !errors.Is(err, status.ResourceExhausted) || !strings.Contains(err.Error(), "boom") {
+				t.Fatalf("%s: expect ResourceExhausted with the beginning of the error, got %v", typ, err)
+			}
+			if err := c.Call(ctx, "Blob.Len", "abc", &n); err != nil || n != 3 {
+				t.Fatalf("%s: expect the connection to stay usable, got %d (%v)", typ, n, err)
+			}
+			cancel()
+			_ = c.Close()
+		}
+	})
+	t.Run("request", func(t *testing.T) {
+		// a client ignoring the negotiated limit
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal("dial error:", err)
+		}
+		defer func() { _ = conn.Close() }()
+		_ = codec.WriteOption(conn, &codec.Option{MagicNumber: codec.MagicNumber, CodecType: codec.JsonType})
+		_, rwc, err := codec.ReadOption(conn)
+		if err != nil {
+			t.Fatal("options error:", err)
+		}
+		cc := codec.NewJsonCodec(rwc)
+		_ = cc.Write(&codec.Header{ServiceMethod: "Blob.Len", Seq: 1, Metadata: map[string]string{"k": strings.Repeat("x", 1024)}}, "abc")
+		_ = cc.Write(&codec.Header{ServiceMethod: "Blob.Len", Seq: 2}, "abcd")
+
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+		var h codec.Header
+		var n int
+		if err := cc.ReadHeader(&h); err != nil || h.Seq != 1 || status.Code(h.ErrorCode) != status.ResourceExhausted {
+			t.Fatalf("expect ResourceExhausted for the oversized header, got %+v (%v)", h, err)
+		}
+		_ = cc.ReadBody(nil)
+		h = codec.Header{}
+		if err := cc.ReadHeader(&h); err != nil || h.Seq != 2 || cc.ReadBody(&n) != nil || n != 4 {
+			t.Fatalf("expect the next request to be served, got %+v %d (%v)", h, n, err)
+		}
+	})
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"vrpc/metadata"
+)
+
+type trailerKey struct{}
+
+// trailer collects the trailing metadata a handler sets for its response
+type trailer struct {
+	mu sync.Mutex
+	md metadata.MD
+}
+
+func (t *trailer) get() map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.md
+}
+
+// SetTrailer sets the trailing metadata sent back with the response of the
+// call being handled with ctx. Multiple invocations merge the metadata.
+func SetTrailer(ctx context.Context, md metadata.MD) error {
+	t, ok := ctx.Value(trailerKey{}).(*trailer)
+	if !ok {
+		return errors.New("rpc server: SetTrailer called outside of a handler")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.md = metadata.Join(t.md, md)
+	return nil
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"log"
+	"vrpc/service"
+)
+
+// OnPanic sets the function reporting panics recovered from service methods
+// and interceptors, by default they are logged with their stack.
+// The calls themselves fail with the panic and its stack in Header.Error.
+func (server *Server) OnPanic(f func(err *service.PanicError)) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.panicHandler = f
+}
+
+func (server *Server) reportPanic(err *service.PanicError) {
+	server.mu.Lock()
+	f := server.panicHandler
+	server.mu.Unlock()
+	if f == nil {
+		log.Println(err)
+		return
+	}
+	f(err)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+)
+
+// Peer describes the client on the other side of a connection.
+type Peer struct {
+	Addr net.Addr             // remote address, nil if the connection doesn't expose one
+	TLS  *tls.ConnectionState // state of the TLS connection, nil without TLS
+}
+
+// Certificate returns the verified certificate the client presented, or nil
+// if the client didn't present one or it wasn't verified.
+func (p *Peer) Certificate() *x509.Certificate {
+	if p.TLS == nil || len(p.TLS.VerifiedChains) == 0 || len(p.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return p.TLS.PeerCertificates[0]
+}
+
+type peerKey struct{}
+
+// PeerFromContext returns the peer of the call handled with ctx, if any.
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok
+}
+
+// newPeer describes the client on conn, completing the TLS handshake first
+// so that the client certificate is known before any request is served.
+func newPeer(conn interface{}) (*Peer, error) {
+	p := new(Peer)
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		p.Addr = c.RemoteAddr()
+	}
+	if c, ok := conn.(*tls.Conn); ok {
+		if err := c.Handshake(); err != nil {
+			return nil, err
+		}
+		state := c.ConnectionState()
+		p.TLS = &state
+	}
+	return p, nil
+}
+
+// AcceptTLS accepts connections on the listener, secures them with config and
+// serves requests for each incoming connection. Setting config.ClientAuth to
+// tls.RequireAndVerifyClientCert enables mutual TLS, see MutualTLSConfig.
+func (server *Server) AcceptTLS(lis net.Listener, config *tls.Config) {
+	server.Accept(tls.NewListener(lis, config))
+}
+
+// MutualTLSConfig returns a TLS config presenting cert and requiring clients
+// to present a certificate signed by one of clientCAs.
+func MutualTLSConfig(cert tls.Certificate, clientCAs *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+}
+
+// AcceptTLS accepts TLS connections on the listener for the DefaultServer.
+func AcceptTLS(lis net.Listener, config *tls.Config) { DefaultServer.AcceptTLS(lis, config) }
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"vrpc/auth"
+	"vrpc/status"
+)
+
+// Rate is the limit of a token bucket: it holds up to Burst calls and refills
+// at Limit calls per second.
+type Rate struct {
+	Limit float64
+	Burst int
+}
+
+// rule selects the callers and methods a Rate applies to.
+type rule struct {
+	caller string // "" for every caller
+	method string // "Service.Method", "Service.*" or "*"
+}
+
+type bucket struct {
+	rate   Rate
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter limits the rate of calls with a token bucket per caller and
+// method. The caller is the authenticated principal of the connection, or
+// the host of the peer if there is none. Limits may be changed at any time.
+type RateLimiter struct {
+	mu        sync.Mutex // protect following
+	rules     map[rule]Rate
+	buckets   map[rule]*bucket // by caller and method
+	lastSweep time.Time
+}
+
+// NewRateLimiter returns a RateLimiter without limits.
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		rules:     make(map[rule]Rate),
+		buckets:   make(map[rule]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// SetLimit limits the calls every caller makes to method, which is either
+// "Service.Method", "Service.*" for every method of a service, or "*".
+// Each caller has its own bucket for every method.
+func (rl *RateLimiter) SetLimit(method string, rate Rate) {
+	rl.SetCallerLimit("", method, rate)
+}
+
+// SetCallerLimit limits the calls caller makes to method, overriding the
+// limits set with SetLimit for it.
+func (rl *RateLimiter) SetCallerLimit(caller, method string, rate Rate) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.rules[rule{caller, method}] = rate
+}
+
+// RemoveLimit removes a limit set with SetLimit or SetCallerLimit.
+func (rl *RateLimiter) RemoveLimit(caller, method string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	delete(rl.rules, rule{caller, method})
+}
+
+// rateLocked returns the most specific rate applying to caller and serviceMethod.
+func (rl *RateLimiter) rateLocked(caller, serviceMethod string) (Rate, bool) {
+	methods := []string{serviceMethod}
+	if dot := strings.LastIndex(serviceMethod, "."); dot >= 0 {
+		methods = append(methods, serviceMethod[:dot]+".*")
+	}
+	methods = append(methods, "*")
+	for _, c := range []string{caller, ""} {
+		for _, m := range methods {
+			if rate, ok := rl.rules[rule{c, m}]; ok {
+				return rate, true
+			}
+		}
+	}
+	return Rate{}, false
+}
+
+// Allow takes a token for a call of caller to serviceMethod. If there is none
+// it returns false along with the time until the next one.
+func (rl *RateLimiter) Allow(caller, serviceMethod string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	if now.Sub(rl.lastSweep) > time.Minute {
+		rl.sweepLocked(now)
+	}
+	rate, ok := rl.rateLocked(caller, serviceMethod)
+	if !ok {
+		return true, 0
+	}
+
+	key := rule{caller, serviceMethod}
+	b := rl.buckets[key]
+	if b == nil {
+		b = &bucket{rate: rate, tokens: float64(rate.Burst), last: now}
+		rl.buckets[key] = b
+	}
+	b.refill(now)
+	if b.rate != rate {
+		// the limit changed, keep the tokens within the new burst
+		b.rate = rate
+		b.tokens = math.Min(b.tokens, float64(rate.Burst))
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if rate.Limit <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+	return false, time.Duration((1 - b.tokens) / rate.Limit * float64(time.Second))
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.rate.Burst), b.tokens+now.Sub(b.last).Seconds()*b.rate.Limit)
+	b.last = now
+}
+
+// sweepLocked forgets the buckets that are full again, they are no different from new ones.
+func (rl *RateLimiter) sweepLocked(now time.Time) {
+	for key, b := range rl.buckets {
+		if b.refill(now); b.tokens >= float64(b.rate.Burst) {
+			delete(rl.buckets, key)
+		}
+	}
+	rl.lastSweep = now
+}
+
+// callerOf identifies the caller of a request for rate limiting.
+func callerOf(ctx context.Context) string {
+	if p, ok := auth.FromContext(ctx); ok && p != nil {
+		return p.Name
+	}
+	if p, ok := PeerFromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// RateLimit returns an interceptor rejecting the calls over the limits of rl
+// with a status.RateLimited error, whose "retry-after" detail tells how long
+// to wait before the next call may pass.
+func RateLimit(rl *RateLimiter) Interceptor {
+	return func(ctx context.Context, inv *Invocation, next Handler) error {
+		if ok, wait := rl.Allow(callerOf(ctx), inv.ServiceMethod); !ok {
+			return status.New(status.RateLimited, "rpc server: rate limit exceeded for "+inv.ServiceMethod).
+				WithDetails(map[string]string{"retry-after": wait.String()})
+		}
+		return next(ctx, inv)
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+	"vrpc/client"
+	"vrpc/status"
+)
+
+func TestRateLimiter(t *testing.T) {
+	rl := NewRateLimiter()
+	rl.SetLimit("Svc.*", Rate{Limit: 10, Burst: 2})
+	rl.SetCallerLimit("batch", "*", Rate{Limit: 0, Burst: 1})
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := rl.Allow("alice", "Svc.Get"); !ok {
+			t.Fatal("expect the burst to pass")
+		}
+	}
+	ok, wait := rl.Allow("alice", "Svc.Get")
+	if ok || wait <= 0 || wait > time.Millisecond*100 {
+		t.Fatalf("expect a call over the burst to wait ~100ms, got %v %v", ok, wait)
+	}
+	if ok, _ := rl.Allow("bob", "Svc.Get"); !ok {
+		t.Fatal("expect every caller to have its own bucket")
+	}
+	if ok, _ := rl.Allow("alice", "Svc.Put"); !ok {
+		t.Fatal("expect every method to have its own bucket")
+	}
+	if ok, _ := rl.Allow("alice", "Other.Get"); !ok {
+		t.Fatal("expect methods without limit to pass")
+	}
+	if ok, _ := rl.Allow("batch", "Other.Get"); !ok {
+		t.Fatal("expect the caller limit to apply")
+	}
+	if ok, _ := rl.Allow("batch", "Other.Get"); ok {
+		t.Fatal("expect the caller limit to apply")
+	}
+
+	time.Sleep(time.Millisecond * 110)
+	if ok, _ := rl.Allow("alice", "Svc.Get"); !ok {
+		t.Fatal("expect the bucket to refill")
+	}
+	rl.SetLimit("Svc.*", Rate{Limit: 0, Burst: 0})
+	if ok, _ := rl.Allow("bob", "Svc.Get"); ok {
+		t.Fatal("expect the new limit to apply at once")
+	}
+	rl.RemoveLimit("", "Svc.*")
+	for i := 0; i < 10; i++ {
+		if ok, _ := rl.Allow("alice", "Svc.Get"); !ok {
+			t.Fatal("expect removed limits to no longer apply")
+		}
+	}
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	s := NewServer()
+	var m Meta
+	_ = s.Register(&m)
+	rl := NewRateLimiter()
+	rl.SetLimit("Meta.Echo", Rate{Limit: 1, Burst: 1})
+	s.Use(RateLimit(rl))
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go s.Accept(l)
+
+	c, err := client.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = c.Close() }()
+	var reply string
+	if err := c.Call(context.Background(), "Meta.Echo", "k", &reply); err != nil {
+		t.Fatal("expect the first call to pass, got", err)
+	}
+	err = c.Call(context.Background(), "Meta.Echo", "k", &reply)
+	var se *status.Error
+	if !errors.As(err, &se) || se.Code != status.RateLimited || se.Details["retry-after"] == "" {
+		t.Fatalf("expect RateLimited with retry-after, got %#v", err)
+	}
+}
@@ -1,9 +1,8 @@
 package server
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
-	"fmt"
 	"io"
 	"log"
 	"net"
@@ -12,8 +11,12 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
+	"vrpc/auth"
 	"vrpc/codec"
+	"vrpc/metadata"
 	"vrpc/service"
+	"vrpc/status"
 )
 
 const (
@@ -25,6 +28,22 @@ const (
 // Server represents an RPC Server.
 type Server struct {
 	serviceMap sync.Map
+
+	mu         sync.Mutex // protect following
+	listeners  map[net.Listener]struct{}
+	conns      map[*serverConn]struct{}
+	inShutdown bool
+	doneChan   chan struct{}   // closed when the server starts shutting down
+	ctx        context.Context // parent of every request context
+	cancel     context.CancelFunc
+
+	interceptors      []Interceptor
+	panicHandler      func(err *service.PanicError)
+	heartbeatInterval time.Duration
+	authenticator     auth.Authenticator
+	limiter           *limiter
+	maxHeaderSize     int
+	maxBodySize       int
 }
 
 // NewServer returns a new Server.
@@ -46,7 +65,7 @@ func (server *Server) Register(rcvr interface{}) error {
 func (server *Server) findService(serviceMethod string) (svc *service.Service, mtype *service.MethodInfo, err error) {
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot < 0 {
-		err = errors.New("rpc server: service/method request ill-formed: " + serviceMethod)
+		err = status.New(status.InvalidArgument, "rpc server: service/method request ill-formed: "+serviceMethod)
 		return
 	}
 
@@ -54,7 +73,7 @@ func (server *Server) findService(serviceMethod string) (svc *service.Service, m
 
 	svci, ok := server.serviceMap.Load(serviceName)
 	if !ok {
-		err = errors.New("rpc server: can't find service " + serviceName)
+		err = status.New(status.NotFound, "rpc server: can't find service "+serviceName)
 		return
 	}
 
@@ -62,7 +81,7 @@ func (server *Server) findService(serviceMethod string) (svc *service.Service, m
 
 	mtype = svc.Method[methodName]
 	if mtype == nil {
-		err = errors.New("rpc server: can't find method " + methodName)
+		err = status.New(status.NotFound, "rpc server: can't find method "+methodName)
 	}
 
 	return
@@ -70,11 +89,20 @@ func (server *Server) findService(serviceMethod string) (svc *service.Service, m
 
 // Accept accepts connections on the listener and serves requests
 // for each incoming connection.
+// Accept returns once the listener fails or the server is shut down.
 func (server *Server) Accept(lis net.Listener) {
+	if !server.trackListener(lis, true) {
+		_ = lis.Close()
+		return
+	}
+	defer server.trackListener(lis, false)
+
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
-			log.Println("rpc server: accept error:", err)
+			if !server.shuttingDown() {
+				log.Println("rpc server: accept error:", err)
+			}
 			return
 		}
 
@@ -82,14 +110,38 @@ func (server *Server) Accept(lis net.Listener) {
 	}
 }
 
+// ServeConn runs the server on a single connection and blocks until the
+// connection is closed or the server is shut down.
 func (server *Server) ServeConn(conn io.ReadWriteCloser) {
+	c := &serverConn{rwc: conn, done: make(chan struct{})}
+	if l := server.getLimiter(); l != nil {
+		c.sem = newSemaphore(l.limits.Conn)
+	}
+	c.ctx, c.cancel = context.WithCancel(server.baseContext())
+	defer close(c.done)
+	defer c.cancel()
 	defer func(conn io.ReadWriteCloser) {
 		_ = conn.Close()
 	}(conn)
+	if !server.trackConn(c, true) {
+		return
+	}
+	defer server.trackConn(c, false)
 
-	var opt codec.Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
-		log.Println("rpc server: options error: ", err)
+	peer, err := newPeer(conn)
+	if err != nil {
+		if !server.shuttingDown() {
+			log.Println("rpc server: tls handshake error: ", err)
+		}
+		return
+	}
+	c.ctx = context.WithValue(c.ctx, peerKey{}, peer)
+
+	opt, conn, err := codec.ReadOption(conn)
+	if err != nil {
+		if !server.shuttingDown() {
+			log.Println("rpc server: options error: ", err)
+		}
 		return
 	}
 
@@ -101,33 +153,170 @@ func (server *Server) ServeConn(conn io.ReadWriteCloser) {
 	newCodeCFunc := codec.NewCodecFuncMap[opt.CodecType]
 	if newCodeCFunc == nil {
 		log.Printf("rpc server: invalid codec type %s", opt.CodecType)
+		refuse(conn, opt, status.Errorf(status.InvalidArgument, "invalid codec type %s", opt.CodecType))
+		return
+	}
+
+	if c.ctx, err = server.authenticate(c.ctx, opt); err != nil {
+		log.Printf("rpc server: authentication of %v failed: %v", peer.Addr, err)
+		if _, ok := err.(*status.Error); !ok {
+			err = status.Wrap(status.Unauthenticated, err)
+		}
+		refuse(conn, opt, err)
 		return
 	}
 
-	server.serveCodec(newCodeCFunc(conn), &opt)
+	// reply with the negotiated options, never echoing the credentials back
+	negotiated := *opt
+	negotiated.Credentials = nil
+	negotiated.HeartbeatInterval = server.negotiateHeartbeat(opt.HeartbeatInterval)
+	negotiated.MaxHeaderSize, negotiated.MaxBodySize = server.negotiateMaxSize(opt)
+	if codec.GetCompressor(opt.Compression) == nil {
+		negotiated.Compression = "" // the client falls back to uncompressed bodies
+	}
+	if err := codec.WriteOption(conn, &negotiated); err != nil {
+		log.Println("rpc server: options error: ", err)
+		return
+	}
+
+	c.opt = &negotiated
+	c.cc = newCodeCFunc(conn)
+	codec.Configure(c.cc, &negotiated)
+	c.touch()
+	if negotiated.HeartbeatInterval > 0 {
+		go c.watchHeartbeat(negotiated.HeartbeatInterval)
+	}
+	server.serveCodec(c)
+}
+
+// refuse tells the client why its connection is rejected.
+func refuse(conn io.Writer, opt *codec.Option, err error) {
+	se := status.Convert(err)
+	reply := *opt
+	reply.Credentials = nil
+	reply.Error, reply.ErrorCode = se.Message, uint32(se.Code)
+	_ = codec.WriteOption(conn, &reply)
+}
+
+// setError stores err in h along with its code and details.
+func setError(h *codec.Header, err error) {
+	se := status.Convert(err)
+	h.Error, h.ErrorCode, h.ErrorDetails = se.Message, uint32(se.Code), se.Details
 }
 
 // invalidRequest is a placeholder for response argv when error occurs
 var invalidRequest = struct{}{}
 
-func (server *Server) serveCodec(cc codec.Codec, opt *codec.Option) {
-	sending := new(sync.Mutex) // make sure to send a complete response
-	wg := new(sync.WaitGroup)  // wait until all request are handled
-	for {
-		req, err := server.readRequest(cc)
+// serverConn holds the state shared by all requests served on one connection
+type serverConn struct {
+	lastRecv int64              // time of the last received message (UnixNano), accessed atomically
+	rwc      io.ReadWriteCloser // the underlying connection
+	cc       codec.Codec
+	opt      *codec.Option
+	ctx      context.Context // cancelled once the connection goes away
+	cancel   context.CancelFunc
+	mu       sync.Mutex                    // protect pending
+	pending  map[uint64]context.CancelFunc // cancel functions of in-flight requests by Seq
+	sending  sync.Mutex                    // make sure to send a complete response
+	wg       sync.WaitGroup                // wait until all request are handled
+	done     chan struct{}                 // closed once the connection is no longer served
+	sem      semaphore                     // bounds the requests handled at the same time, see ConcurrencyLimits
+}
+
+// stopReading interrupts a pending read on the connection, so that the
+// connection stops accepting new requests.
+// Connections without read deadlines are only stopped when closed.
+func (c *serverConn) stopReading() {
+	if d, ok := c.rwc.(interface{ SetReadDeadline(time.Time) error }); ok {
+		_ = d.SetReadDeadline(time.Now())
+	}
+}
+
+// newRequestContext derives the context of a request from the connection,
+// bounded by the caller's deadline and registered so that a cancel message
+// from the client can cancel it. The context carries the request metadata
+// and collects the trailing metadata of the response.
+func (c *serverConn) newRequestContext(req *request) (context.Context, context.CancelFunc) {
+	h := req.h
+	ctx := metadata.NewIncomingContext(c.ctx, metadata.MD(h.Metadata))
+	ctx = context.WithValue(ctx, trailerKey{}, req.trailer)
+	var cancel context.CancelFunc
+	if h.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending == nil {
+		c.pending = make(map[uint64]context.CancelFunc)
+	}
+	c.pending[h.Seq] = cancel
+	return ctx, func() {
+		c.mu.Lock()
+		delete(c.pending, h.Seq)
+		c.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancelRequest cancels the in-flight request with seq, if any.
+func (c *serverConn) cancelRequest(seq uint64) {
+	c.mu.Lock()
+	cancel := c.pending[seq]
+	c.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+func (server *Server) serveCodec(c *serverConn) {
+	for !server.shuttingDown() {
+		req, err := server.readRequest(c.cc)
+		c.touch()
 		if err != nil {
-			if req == nil {
+			if req == nil || server.shuttingDown() {
+				if !server.shuttingDown() {
+					c.cancel() // the client went away, in-flight requests are abandoned
+				}
 				break // it's not possible to recover, so close the connection
 			}
-			req.h.Error = err.Error()
-			server.sendResponse(cc, req.h, invalidRequest, sending)
+			setError(req.h, err)
+			req.h.Metadata = nil
+			server.sendResponse(c.cc, req.h, invalidRequest, &c.sending)
 			continue
 		}
-		wg.Add(1)
-		go server.handleRequest(cc, req, sending, wg, opt.HandleTimeout)
+		switch req.h.Type {
+		case codec.TypeCancel:
+			c.cancelRequest(req.h.Seq)
+			continue
+		case codec.TypePing:
+			server.sendResponse(c.cc, &codec.Header{Seq: req.h.Seq, Type: codec.TypePong}, invalidRequest, &c.sending)
+			continue
+		}
+		if server.shuttingDown() {
+			// the request raced with shutdown, tell the client instead of dropping it
+			setError(req.h, ErrServerClosed)
+			server.sendResponse(c.cc, req.h, invalidRequest, &c.sending)
+			break
+		}
+		req.trailer = new(trailer)
+		req.ctx, req.cancel = c.newRequestContext(req)
+		c.wg.Add(1)
+		server.admit(c, req, func(release func()) {
+			req.release = release
+			go server.handleRequest(c, req)
+		}, func(err error) {
+			defer c.wg.Done()
+			defer req.cancel()
+			setError(req.h, err)
+			req.h.Metadata = nil
+			server.sendResponse(c.cc, req.h, invalidRequest, &c.sending)
+		})
 	}
-	wg.Wait()
-	_ = cc.Close()
+	c.wg.Wait()
+	_ = c.cc.Close()
 }
 
 // request stores all information of a call
@@ -136,13 +325,22 @@ type request struct {
 	argv, replyv reflect.Value // argv and replyv of request
 	minfo        *service.MethodInfo
 	svc          *service.Service
+	ctx          context.Context // carries the caller's deadline, cancellation and metadata
+	cancel       context.CancelFunc
+	trailer      *trailer // trailing metadata of the response
+	release      func()   // frees the concurrency slots of the request once its method returns
 }
 
 func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 	var h codec.Header
 	err := cc.ReadHeader(&h)
+	if errors.Is(err, codec.ErrHeaderTooLarge) {
+		// the header was skipped, its Seq is still known so that the client can be answered
+		log.Println("rpc server: read header error:", err)
+		return &h, err
+	}
 	if err != nil {
-		if err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+		if err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) && !server.shuttingDown() {
 			log.Println("rpc server: read header error:", err)
 		}
 		return nil, err
@@ -154,12 +352,23 @@ func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 	h, err := server.readRequestHeader(cc)
 	if err != nil {
+		if h != nil {
+			return &request{h: h}, err
+		}
 		return nil, err
 	}
 
 	req := &request{h: h}
+	if h.Type == codec.TypeCancel || h.Type == codec.TypePing {
+		// control messages carry no arguments
+		_ = cc.ReadBody(nil)
+		return req, nil
+	}
+
 	req.svc, req.minfo, err = server.findService(h.ServiceMethod)
 	if err != nil {
+		// discard the body so that the next request can be read
+		_ = cc.ReadBody(nil)
 		return req, err
 	}
 	req.argv = req.minfo.NewArgv()
@@ -171,8 +380,13 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 		argvi = req.argv.Addr().Interface()
 	}
 
+	// frames keep the stream in sync even if the body can't be decoded
 	if err = cc.ReadBody(argvi); err != nil {
 		log.Println("rpc server: read argv err:", err)
+		if errors.Is(err, codec.ErrBodyTooLarge) {
+			return req, err
+		}
+		return req, status.Wrap(status.InvalidArgument, err)
 	}
 
 	return req, nil
@@ -183,45 +397,115 @@ func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interfa
 	defer sending.Unlock()
 	log.Println("Header:", h, "; Body:", body)
 	err := cc.Write(h, body)
+	if errors.Is(err, codec.ErrBodyTooLarge) {
+		// nothing was written, the client gets the error instead of the reply
+		setError(h, err)
+		err = cc.Write(h, invalidRequest)
+	}
+	if errors.Is(err, codec.ErrHeaderTooLarge) {
+		// a panic stack, a long error or large trailers, the client still gets an answer
+		trimHeader(h)
+		err = cc.Write(h, invalidRequest)
+	}
 	if err != nil {
 		log.Println("rpc server: write response error:", err)
 	}
 }
 
-func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
-	defer wg.Done()
-	called := make(chan struct{})
-	sent := make(chan struct{})
+// maxTrimmedError is the length an error message is cut to by trimHeader
+const maxTrimmedError = 128
+
+// trimHeader drops the trailing metadata and error details of a response
+// header too large to be sent, keeping the beginning of the error message.
+func trimHeader(h *codec.Header) {
+	msg := h.Error
+	if len(msg) > maxTrimmedError {
+		n := maxTrimmedError
+		for n > 0 && !utf8.RuneStart(msg[n]) {
+			n--
+		}
+		msg = msg[:n] + "..."
+	}
+	h.Error = "rpc server: response header exceeds the maximum size"
+	if msg != "" {
+		h.Error += ": " + msg
+	}
+	h.ErrorCode, h.ErrorDetails = uint32(status.ResourceExhausted), nil
+	h.Metadata = nil
+}
+
+// handleRequest invokes the service method with a context that is cancelled
+// when the caller's deadline or the handle timeout expires, the caller cancels
+// the call, the connection goes away or the server is shut down forcibly.
+// The response is sent as soon as the context is done, even if the method
+// keeps running.
+func (server *Server) handleRequest(c *serverConn, req *request) {
+	defer c.wg.Done()
+	defer req.cancel()
+	ctx, cancel := req.ctx, context.CancelFunc(func() {})
+	timeout := c.opt.HandleTimeout
+	if timeout != 0 {
+		ctx, cancel = context.WithTimeout(req.ctx, timeout)
+	}
+	defer cancel()
 
-	log.Println("timeout:", timeout)
+	// interceptors get their own copy of the header, which is reused for the response
+	h := *req.h
+	inv := &Invocation{ServiceMethod: h.ServiceMethod, Header: &h, Argv: req.argv, Replyv: req.replyv}
+	handler := server.chain(func(ctx context.Context, inv *Invocation) error {
+		return req.svc.CallContext(ctx, req.minfo, inv.Argv, inv.Replyv)
+	})
 
+	// buffered, so that a method finishing after the timeout doesn't block forever
+	called := make(chan error, 1)
 	go func() {
-		err := req.svc.Call(req.minfo, req.argv, req.replyv)
-		called <- struct{}{}
-		if err != nil {
-			req.h.Error = err.Error()
-			server.sendResponse(cc, req.h, invalidRequest, sending)
-			sent <- struct{}{}
-			return
+		defer req.release()
+		defer func() {
+			// panics of methods are recovered by the service, these come from interceptors
+			if v := recover(); v != nil {
+				perr := req.svc.NewPanicError(req.minfo, v)
+				server.reportPanic(perr)
+				called <- perr
+			}
+		}()
+		err := handler(ctx, inv)
+		// reported here, the response may have been sent already if the method
+		// panicked after the timeout
+		var perr *service.PanicError
+		if errors.As(err, &perr) {
+			server.reportPanic(perr)
 		}
-
-		server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
-		sent <- struct{}{}
+		called <- err
 	}()
 
-	if timeout == 0 {
-		<-called
-		<-sent
-		return
-	}
+	var body interface{} = invalidRequest
 	select {
-	case <-time.After(timeout):
-		log.Printf("rpc server: request handle timeout: expect within %s", timeout)
-		req.h.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
-		server.sendResponse(cc, req.h, invalidRequest, sending)
-	case <-called:
-		<-sent
+	case <-ctx.Done():
+		switch {
+		case c.ctx.Err() != nil:
+			setError(req.h, status.New(status.Unavailable, "rpc server: request canceled: "+c.ctx.Err().Error()))
+		case req.ctx.Err() == context.Canceled:
+			return // the client gave up the call and won't read the response
+		case req.ctx.Err() == context.DeadlineExceeded:
+			setError(req.h, status.Errorf(status.DeadlineExceeded, "rpc server: request deadline exceeded: expect within %s", req.h.Timeout))
+		default:
+			log.Printf("rpc server: request handle timeout: expect within %s", timeout)
+			setError(req.h, status.Errorf(status.DeadlineExceeded, "rpc server: request handle timeout: expect within %s", timeout))
+		}
+	case err := <-called:
+		var perr *service.PanicError
+		if errors.As(err, &perr) {
+			err = status.Wrap(status.Internal, err)
+		}
+		if err != nil {
+			setError(req.h, err)
+		} else {
+			body = req.replyv.Interface()
+		}
 	}
+	// the response carries the trailing metadata instead of the request metadata
+	req.h.Metadata = req.trailer.get()
+	server.sendResponse(c.cc, req.h, body, &c.sending)
 }
 
 // ServeHTTP implements a http.Handler that answers RPC requests.
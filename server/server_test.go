@@ -0,0 +1,319 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"vrpc/client"
+	"vrpc/codec"
+	"vrpc/metadata"
+	"vrpc/service"
+	"vrpc/status"
+)
+
+// Sleeper reports the context error seen by Wait on its own waited channel,
+// so that tests running one after another don't see each other's results.
+type Sleeper struct {
+	waited chan error
+}
+
+func (s *Sleeper) Sleep(d time.Duration, reply *int) error {
+	time.Sleep(d)
+	*reply = 1
+	return nil
+}
+
+func startSleeper(t *testing.T) (*Server, string, *Sleeper) {
+	s := NewServer()
+	sl := &Sleeper{waited: make(chan error, 1)}
+	_ = s.Register(sl)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	go s.Accept(l)
+	return s, l.Addr().String(), sl
+}
+
+func (s *Sleeper) Wait(ctx context.Context, d time.Duration, reply *string) error {
+	select {
+	case <-ctx.Done():
+		*reply = ctx.Err().Error()
+		if s.waited != nil {
+			s.waited <- ctx.Err()
+		}
+	case <-time.After(d):
+	}
+	return nil
+}
+
+func TestServer_HandleTimeoutCancelsContext(t *testing.T) {
+	_, addr, sl := startSleeper(t)
+	c, err := client.Dial("tcp", addr, &codec.Option{HandleTimeout: time.Millisecond * 100})
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = c.Close() }()
+
+	var reply string
+	err = c.Call(context.Background(), "Sleeper.Wait", time.Second, &reply)
+	if err == nil || !strings.Contains(err.Error(), "handle timeout") {
+		t.Fatal("expect a handle timeout error, got", err)
+	}
+	select {
+	case err := <-sl.waited:
+		if err != context.DeadlineExceeded {
+			t.Fatal("expect the method to see the deadline, got", err)
+		}
+	case <-time.After(time.Millisecond * 500):
+		t.Fatal("expect the method context to be cancelled")
+	}
+}
+
+func TestServer_ClientGoneCancelsContext(t *testing.T) {
+	_, addr, sl := startSleeper(t)
+	c, err := client.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+
+	var reply string
+	c.Go("Sleeper.Wait", time.Second, &reply, nil)
+	time.Sleep(time.Millisecond * 50)
+	_ = c.Close()
+	select {
+	case err := <-sl.waited:
+		if err != context.Canceled {
+			t.Fatal("expect the method to see the cancellation, got", err)
+		}
+	case <-time.After(time.Millisecond * 500):
+		t.Fatal("expect the method context to be cancelled once the client goes away")
+	}
+}
+
+func TestServer_ClientDeadlinePropagates(t *testing.T) {
+	_, addr, sl := startSleeper(t)
+	c, err := client.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = c.Close() }()
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+		var reply string
+		_ = c.Call(ctx, "Sleeper.Wait", time.Second, &reply)
+		select {
+		case err := <-sl.waited:
+			if err != context.DeadlineExceeded {
+				t.Fatal("expect the method to see the caller's deadline, got", err)
+			}
+		case <-time.After(time.Millisecond * 500):
+			t.Fatal("expect the method context to carry the caller's deadline")
+		}
+	})
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(time.Millisecond*50, cancel)
+		var reply string
+		_ = c.Call(ctx, "Sleeper.Wait", time.Second, &reply)
+		select {
+		case err := <-sl.waited:
+			if err != context.Canceled {
+				t.Fatal("expect the method to see the cancellation, got", err)
+			}
+		case <-time.After(time.Millisecond * 500):
+			t.Fatal("expect the method context to be cancelled by the client")
+		}
+	})
+	t.Run("connection still usable", func(t *testing.T) {
+		var reply int
+		if err := c.Call(context.Background(), "Sleeper.Sleep", time.Millisecond, &reply); err != nil || reply != 1 {
+			t.Fatalf("expect a normal call to succeed, got %d (%v)", reply, err)
+		}
+	})
+}
+
+type Meta int
+
+// Echo replies with the incoming value of key and sends it back as trailer
+func (m Meta) Echo(ctx context.Context, key string, reply *string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	*reply = md.Get(key)
+	return SetTrailer(ctx, metadata.Pairs("echo-"+key, *reply))
+}
+
+func TestServer_Metadata(t *testing.T) {
+	s := NewServer()
+	var m Meta
+	_ = s.Register(&m)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go s.Accept(l)
+
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+		c, err := client.Dial("tcp", l.Addr().String(), &codec.Option{CodecType: typ})
+		if err != nil {
+			t.Fatal("dial error:", err)
+		}
+
+		var trailer metadata.MD
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "Trace-ID", "abc")
+		ctx = client.WithTrailer(ctx, &trailer)
+		var reply string
+		if err := c.Call(ctx, "Meta.Echo", "trace-id", &reply); err != nil || reply != "abc" {
+			t.Fatalf("%s: expect handler to read the metadata, got %q (%v)", typ, reply, err)
+		}
+		if trailer.Get("echo-trace-id") != "abc" {
+			t.Fatalf("%s: expect trailing metadata, got %v", typ, trailer)
+		}
+		_ = c.Close()
+	}
+}
+
+type Panicker int
+
+func (p Panicker) Panic(msg string, reply *int) error {
+	panic(msg)
+}
+
+// Late panics after d, once the caller has been answered
+func (p Panicker) Late(d time.Duration, reply *int) error {
+	time.Sleep(d)
+	panic("late")
+}
+
+func TestServer_RecoverPanic(t *testing.T) {
+	s := NewServer()
+	var p Panicker
+	var m Meta
+	_ = s.Register(&p)
+	_ = s.Register(&m)
+	reported := make(chan *service.PanicError, 2)
+	s.OnPanic(func(err *service.PanicError) { reported <- err })
+	s.Use(func(ctx context.Context, inv *Invocation, next Handler) error {
+		if inv.Argv.Kind() == reflect.String && inv.Argv.String() == "interceptor" {
+			panic("boom in interceptor")
+		}
+		return next(ctx, inv)
+	})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go s.Accept(l)
+
+	c, err := client.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = c.Close() }()
+
+	var reply int
+	err = c.Call(context.Background(), "Panicker.Panic", "boom", &reply)
+	if err == nil || !strings.Contains(err.Error(), "Panicker.Panic panic: boom") || !strings.Contains(err.Error(), "goroutine") {
+		t.Fatal("expect the panic and its stack in the error, got", err)
+	}
+	if perr := <-reported; perr.Value != "boom" {
+		t.Fatal("expect the panic to be reported, got", perr.Value)
+	}
+
+	var str string
+	err = c.Call(context.Background(), "Meta.Echo", "interceptor", &str)
+	if err == nil || !strings.Contains(err.Error(), "boom in interceptor") {
+		t.Fatal("expect the interceptor panic to be recovered, got", err)
+	}
+	if perr := <-reported; perr.ServiceMethod != "Meta.Echo" {
+		t.Fatal("expect the interceptor panic to be reported, got", perr.ServiceMethod)
+	}
+	if _, m, _ := s.findService("Meta.Echo"); m.NumPanics() != 1 {
+		t.Fatal("expect the interceptor panic to be counted, got", m.NumPanics())
+	}
+
+	if err := c.Call(context.Background(), "Meta.Echo", "key", &str); err != nil {
+		t.Fatal("expect the server to keep serving after a panic, got", err)
+	}
+}
+
+func TestServer_ReportLatePanic(t *testing.T) {
+	s := NewServer()
+	var p Panicker
+	_ = s.Register(&p)
+	reported := make(chan *service.PanicError, 1)
+	s.OnPanic(func(err *service.PanicError) { reported <- err })
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go s.Accept(l)
+
+	c, err := client.Dial("tcp", l.Addr().String(), &codec.Option{HandleTimeout: time.Millisecond * 50})
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer func() { _ = c.Close() }()
+
+	var reply int
+	if err := c.Call(context.Background(), "Panicker.Late", time.Millisecond*150, &reply); err == nil || !strings.Contains(err.Error(), "handle timeout") {
+		t.Fatal("expect a handle timeout, got", err)
+	}
+	select {
+	case perr := <-reported:
+		if perr.Value != "late" {
+			t.Fatal("unexpected panic reported:", perr.Value)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect a panic after the timeout to be reported")
+	}
+}
+
+type Fail int
+
+func (f Fail) Validate(n int, reply *int) error {
+	if n < 0 {
+		return status.New(status.InvalidArgument, "n must not be negative").WithDetails(map[string]string{"field": "n"})
+	}
+	return errors.New("plain error")
+}
+
+func TestServer_ErrorCodes(t *testing.T) {
+	s := NewServer()
+	var f Fail
+	_ = s.Register(&f)
+	_ = s.Register(&Sleeper{})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go s.Accept(l)
+
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+		c, err := client.Dial("tcp", l.Addr().String(), &codec.Option{CodecType: typ, HandleTimeout: time.Millisecond * 50})
+		if err != nil {
+			t.Fatal("dial error:", err)
+		}
+		var reply int
+		ctx := context.Background()
+
+		err = c.Call(ctx, "Fail.Validate", -1, &reply)
+		var se *status.Error
+		if !errors.As(err, &se) || se.Code != status.InvalidArgument || se.Details["field"] != "n" {
+			t.Fatalf("%s: expect InvalidArgument with details, got %#v", typ, err)
+		}
+		if err.Error() != "n must not be negative" {
+			t.Fatalf("%s: unexpected message %q", typ, err)
+		}
+		if err = c.Call(ctx, "Fail.Validate", 1, &reply); !errors.Is(err, status.Unknown) {
+			t.Fatalf("%s: expect Unknown, got %v", typ, err)
+		}
+		if err = c.Call(ctx, "Fail.Validate", "not a number", &reply); !errors.Is(err, status.InvalidArgument) {
+			t.Fatalf("%s: expect a malformed argument to be rejected, got %v", typ, err)
+		}
+		if err = c.Call(ctx, "Fail.Missing", 1, &reply); !errors.Is(err, status.NotFound) {
+			t.Fatalf("%s: expect NotFound, got %v", typ, err)
+		}
+		if err = c.Call(ctx, "Sleeper.Sleep", time.Second, &reply); !errors.Is(err, status.DeadlineExceeded) {
+			t.Fatalf("%s: expect DeadlineExceeded, got %v", typ, err)
+		}
+		// the message is not taken as a format string
+		if err = c.Call(ctx, "Fail.Missing%d", 1, &reply); err == nil || !strings.HasSuffix(err.Error(), "Missing%d") {
+			t.Fatalf("%s: unexpected error %v", typ, err)
+		}
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"net"
+	"vrpc/status"
+)
+
+// ErrServerClosed is returned to requests that arrive while the server is shutting down.
+var ErrServerClosed = status.New(status.Unavailable, "rpc server: server closed")
+
+// Shutdown gracefully shuts down the server: it closes all listeners, stops
+// reading new requests on every live connection and waits for in-flight
+// requests to be answered before closing the connections.
+// If ctx expires first, the contexts of the remaining requests are cancelled,
+// their connections are closed forcibly and ctx's error is returned.
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.mu.Lock()
+	if !server.inShutdown {
+		server.inShutdown = true
+		close(server.getDoneChanLocked())
+	}
+	for lis := range server.listeners {
+		_ = lis.Close()
+	}
+	conns := make([]*serverConn, 0, len(server.conns))
+	for c := range server.conns {
+		c.stopReading()
+		conns = append(conns, c)
+	}
+	server.mu.Unlock()
+
+	for _, c := range conns {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			server.cancelBaseContext()
+			for _, c := range conns {
+				_ = c.rwc.Close()
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// baseContext returns the context all request contexts derive from,
+// it is cancelled when Shutdown gives up waiting.
+func (server *Server) baseContext() context.Context {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.ctx == nil {
+		server.ctx, server.cancel = context.WithCancel(context.Background())
+	}
+	return server.ctx
+}
+
+func (server *Server) cancelBaseContext() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.cancel != nil {
+		server.cancel()
+	}
+}
+
+func (server *Server) shuttingDown() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.inShutdown
+}
+
+// getDoneChanLocked returns the channel closed at shutdown, server.mu must be held.
+func (server *Server) getDoneChanLocked() chan struct{} {
+	if server.doneChan == nil {
+		server.doneChan = make(chan struct{})
+	}
+	return server.doneChan
+}
+
+func (server *Server) getDoneChan() <-chan struct{} {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.getDoneChanLocked()
+}
+
+// trackListener adds or removes lis, it reports false if the server is shutting down.
+func (server *Server) trackListener(lis net.Listener, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if add {
+		if server.inShutdown {
+			return false
+		}
+		if server.listeners == nil {
+			server.listeners = make(map[net.Listener]struct{})
+		}
+		server.listeners[lis] = struct{}{}
+	} else {
+		delete(server.listeners, lis)
+	}
+	return true
+}
+
+// trackConn adds or removes c, it reports false if the server is shutting down.
+func (server *Server) trackConn(c *serverConn, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if add {
+		if server.inShutdown {
+			return false
+		}
+		if server.conns == nil {
+			server.conns = make(map[*serverConn]struct{})
+		}
+		server.conns[c] = struct{}{}
+	} else {
+		delete(server.conns, c)
+	}
+	return true
+}
+
+// Shutdown gracefully shuts down the DefaultServer.
+func Shutdown(ctx context.Context) error { return DefaultServer.Shutdown(ctx) }
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+	"vrpc/client"
+)
+
+func TestServer_Shutdown(t *testing.T) {
+	s, addr, _ := startSleeper(t)
+	c, err := client.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+
+	var reply int
+	call := c.Go("Sleeper.Sleep", time.Millisecond*200, &reply, nil)
+	time.Sleep(time.Millisecond * 50)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal("expect in-flight requests to be drained, got", err)
+	}
+	if <-call.Done; call.Error != nil || reply != 1 {
+		t.Fatalf("expect in-flight call to succeed, got %d (%v)", reply, call.Error)
+	}
+
+	if _, err := client.Dial("tcp", addr); err == nil {
+		t.Fatal("expect the listener to be closed after shutdown")
+	}
+}
+
+func TestServer_ShutdownDeadline(t *testing.T) {
+	s, addr, _ := startSleeper(t)
+	c, err := client.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+
+	var reply int
+	call := c.Go("Sleeper.Sleep", time.Second, &reply, nil)
+	time.Sleep(time.Millisecond * 50)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatal("expect shutdown to hit the deadline, got", err)
+	}
+	if <-call.Done; call.Error == nil {
+		t.Fatal("expect the call to fail once the connection is closed")
+	}
+}
@@ -6,16 +6,23 @@ import (
 )
 
 type MethodInfo struct {
-	method    reflect.Method
-	ArgType   reflect.Type
-	ReplyType reflect.Type
-	numCalls  uint64
+	method      reflect.Method
+	ArgType     reflect.Type
+	ReplyType   reflect.Type
+	WithContext bool // the method takes a context.Context as its first argument
+	numCalls    uint64
+	numPanics   uint64
 }
 
 func (m *MethodInfo) NumCalls() uint64 {
 	return atomic.LoadUint64(&m.numCalls)
 }
 
+// NumPanics returns how many calls of the method panicked.
+func (m *MethodInfo) NumPanics() uint64 {
+	return atomic.LoadUint64(&m.numPanics)
+}
+
 func (m *MethodInfo) NewArgv() reflect.Value {
 	var argv reflect.Value
 
@@ -1,9 +1,12 @@
 package service
 
 import (
+	"context"
+	"fmt"
 	"go/ast"
 	"log"
 	"reflect"
+	"runtime/debug"
 	"sync/atomic"
 )
 
@@ -27,6 +30,11 @@ func NewService(rcvr interface{}) *Service {
 	return s
 }
 
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
 func (s *Service) registerMethods() {
 	s.Method = make(map[string]*MethodInfo)
 
@@ -36,20 +44,23 @@ func (s *Service) registerMethods() {
 
 		// 限制方法的格式:
 		//     func (t *T) MethodName(argType T1, replyType *T2) error
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		//     func (t *T) MethodName(ctx context.Context, argType T1, replyType *T2) error
+		if mType.NumOut() != 1 || mType.Out(0) != typeOfError {
 			continue
 		}
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		withContext := mType.NumIn() == 4 && mType.In(1) == typeOfContext
+		if mType.NumIn() != 3 && !withContext {
 			continue
 		}
-		argType, replyType := mType.In(1), mType.In(2)
+		argType, replyType := mType.In(mType.NumIn()-2), mType.In(mType.NumIn()-1)
 		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
 			continue
 		}
 		s.Method[method.Name] = &MethodInfo{
-			method:    method,
-			ArgType:   argType,
-			ReplyType: replyType,
+			method:      method,
+			ArgType:     argType,
+			ReplyType:   replyType,
+			WithContext: withContext,
 		}
 		log.Printf("rpc server: register %s.%s\n", s.Name, method.Name)
 	}
@@ -59,10 +70,45 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
 
+// Call invokes the method with a background context.
 func (s *Service) Call(m *MethodInfo, argv, replyv reflect.Value) error {
+	return s.CallContext(context.Background(), m, argv, replyv)
+}
+
+// PanicError is returned by CallContext when the method panics.
+type PanicError struct {
+	ServiceMethod string      // 格式: [服务名].[方法名]
+	Value         interface{} // the value passed to panic
+	Stack         []byte      // stack trace of the panicking goroutine
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("rpc server: %s panic: %v\n%s", e.ServiceMethod, e.Value, e.Stack)
+}
+
+// NewPanicError counts a panic of the method and wraps the recovered value v
+// with the current stack, it must be called by the deferred function that recovered v.
+func (s *Service) NewPanicError(m *MethodInfo, v interface{}) *PanicError {
+	atomic.AddUint64(&m.numPanics, 1)
+	return &PanicError{ServiceMethod: s.Name + "." + m.method.Name, Value: v, Stack: debug.Stack()}
+}
+
+// CallContext invokes the method, ctx is passed to methods that accept a context.Context.
+// A panic in the method is recovered and returned as a *PanicError.
+func (s *Service) CallContext(ctx context.Context, m *MethodInfo, argv, replyv reflect.Value) (err error) {
 	atomic.AddUint64(&m.numCalls, 1)
+	defer func() {
+		if v := recover(); v != nil {
+			err = s.NewPanicError(m, v)
+		}
+	}()
+
 	f := m.method.Func
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv})
+	in := []reflect.Value{s.rcvr, argv, replyv}
+	if m.WithContext {
+		in = []reflect.Value{s.rcvr, reflect.ValueOf(ctx), argv, replyv}
+	}
+	returnValues := f.Call(in)
 	if errInter := returnValues[0].Interface(); errInter != nil {
 		return errInter.(error)
 	}
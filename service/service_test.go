@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"reflect"
 	"testing"
 )
@@ -59,3 +60,57 @@ func TestService(t *testing.T) {
 		})
 	}
 }
+
+type Ctx int
+
+func (c Ctx) Wait(ctx context.Context, args int, reply *string) error {
+	<-ctx.Done()
+	*reply = ctx.Err().Error()
+	return nil
+}
+
+func TestService_CallContext(t *testing.T) {
+	var c Ctx
+	s := NewService(&c)
+
+	mType := s.Method["Wait"]
+	if mType == nil || !mType.WithContext || mType.ArgType.Kind() != reflect.Int {
+		t.Fatal("expect Wait to be registered as a context-aware method")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	replyv := mType.NewReplyv()
+	if err := s.CallContext(ctx, mType, reflect.ValueOf(1), replyv); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if *replyv.Interface().(*string) != context.Canceled.Error() {
+		t.Fatal("expect the method to see the cancelled context")
+	}
+}
+
+func (f Foo) Div(args Args, reply *int) error {
+	*reply = args.Num1 / args.Num2
+	return nil
+}
+
+func TestService_CallPanic(t *testing.T) {
+	var foo Foo
+	s := NewService(&foo)
+
+	mType := s.Method["Div"]
+	argv := mType.NewArgv()
+	argv.Set(reflect.ValueOf(Args{Num1: 1}))
+	err := s.Call(mType, argv, mType.NewReplyv())
+
+	perr, ok := err.(*PanicError)
+	if !ok {
+		t.Fatal("expect a *PanicError, got", err)
+	}
+	if perr.ServiceMethod != "Foo.Div" || len(perr.Stack) == 0 {
+		t.Fatalf("expect the method name and stack, got %q", perr.ServiceMethod)
+	}
+	if mType.NumCalls() != 1 || mType.NumPanics() != 1 {
+		t.Fatal("expect the panic to be counted")
+	}
+}
@@ -0,0 +1,160 @@
+// Package status defines the errors of RPC calls, which carry a Code across
+// the wire so that callers can tell failures apart without matching messages.
+//
+//	if errors.Is(err, status.NotFound) { ... }
+//
+//	var se *status.Error
+//	if errors.As(err, &se) { log.Println(se.Code, se.Details) }
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Code classifies an RPC error, the values up to Unauthenticated are the ones used by gRPC.
+// A Code is an error itself, so that errors.Is(err, code) reports whether
+// err is an *Error with that code.
+type Code uint32
+
+const (
+	OK                 Code = iota // not an error
+	Canceled                       // the call was cancelled, typically by the caller
+	Unknown                        // the error doesn't carry a code
+	InvalidArgument                // the arguments are malformed
+	DeadlineExceeded               // the call didn't complete in time
+	NotFound                       // the service or method doesn't exist
+	AlreadyExists                  // the entity a call attempted to create already exists
+	PermissionDenied               // the caller isn't allowed to make the call
+	ResourceExhausted              // the server is out of capacity
+	FailedPrecondition             // the system isn't in a state required by the call
+	Aborted                        // the call was aborted, typically by a concurrency conflict
+	OutOfRange                     // the call attempted an operation past the valid range
+	Unimplemented                  // the operation isn't supported
+	Internal                       // an invariant of the server is broken, e.g. a method panicked
+	Unavailable                    // the server or connection is unavailable, the call may be retried
+	DataLoss                       // unrecoverable data loss or corruption
+	Unauthenticated                // the caller has no valid credentials
+	RateLimited                    // the caller exceeded its rate limit, it should back off before retrying
+)
+
+var codeNames = [...]string{
+	OK:                 "OK",
+	Canceled:           "Canceled",
+	Unknown:            "Unknown",
+	InvalidArgument:    "InvalidArgument",
+	DeadlineExceeded:   "DeadlineExceeded",
+	NotFound:           "NotFound",
+	AlreadyExists:      "AlreadyExists",
+	PermissionDenied:   "PermissionDenied",
+	ResourceExhausted:  "ResourceExhausted",
+	FailedPrecondition: "FailedPrecondition",
+	Aborted:            "Aborted",
+	OutOfRange:         "OutOfRange",
+	Unimplemented:      "Unimplemented",
+	Internal:           "Internal",
+	Unavailable:        "Unavailable",
+	DataLoss:           "DataLoss",
+	Unauthenticated:    "Unauthenticated",
+	RateLimited:        "RateLimited",
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) && codeNames[c] != "" {
+		return codeNames[c]
+	}
+	return fmt.Sprintf("Code(%d)", uint32(c))
+}
+
+// Error returns the name of the code, making Code usable as errors.Is target.
+func (c Code) Error() string { return c.String() }
+
+// Error is an RPC error, sent in Header.Error along with its code and details.
+type Error struct {
+	Code    Code
+	Message string
+	Details map[string]string // optional key/value information about the error
+	cause   error             // the local error it was converted from, never sent
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Is reports whether target is the code of e, or an *Error with the same code and message.
+func (e *Error) Is(target error) bool {
+	switch t := target.(type) {
+	case Code:
+		return e.Code == t
+	case *Error:
+		return e.Code == t.Code && e.Message == t.Message
+	}
+	return false
+}
+
+// Unwrap returns the local error e was converted from, if any.
+func (e *Error) Unwrap() error { return e.cause }
+
+// New returns an error with code and msg.
+func New(code Code, msg string) *Error {
+	return &Error{Code: code, Message: msg}
+}
+
+// Errorf returns an error with code and a message formatted according to format.
+// Errors in the arguments wrapped with %w are reachable through errors.Unwrap.
+func Errorf(code Code, format string, a ...interface{}) *Error {
+	err := fmt.Errorf(format, a...)
+	return &Error{Code: code, Message: err.Error(), cause: errors.Unwrap(err)}
+}
+
+// Wrap returns an error with code and the message of err, err stays
+// reachable through errors.Unwrap. It returns nil if err is nil.
+func Wrap(code Code, err error) *Error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: code, Message: err.Error(), cause: err}
+}
+
+// WithDetails returns a copy of e with details added.
+func (e *Error) WithDetails(details map[string]string) *Error {
+	c := *e
+	c.Details = make(map[string]string, len(e.Details)+len(details))
+	for k, v := range e.Details {
+		c.Details[k] = v
+	}
+	for k, v := range details {
+		c.Details[k] = v
+	}
+	return &c
+}
+
+// Convert returns err as an *Error. An *Error wrapped inside err keeps its
+// code and details but takes the message of err, context errors get
+// Canceled or DeadlineExceeded and other errors Unknown.
+// It returns nil if err is nil.
+func Convert(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var se *Error
+	switch {
+	case errors.As(err, &se):
+		if se == err {
+			return se
+		}
+		return &Error{Code: se.Code, Message: err.Error(), Details: se.Details, cause: err}
+	case errors.Is(err, context.Canceled):
+		return Wrap(Canceled, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return Wrap(DeadlineExceeded, err)
+	}
+	return Wrap(Unknown, err)
+}
+
+// CodeOf returns the code of err, OK if err is nil.
+func CodeOf(err error) Code {
+	if err == nil {
+		return OK
+	}
+	return Convert(err).Code
+}
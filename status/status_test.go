@@ -0,0 +1,48 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestErrorIs(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", New(NotFound, "no such thing"))
+	if !errors.Is(err, NotFound) || errors.Is(err, Internal) {
+		t.Fatal("expect errors.Is to match the code only")
+	}
+	if !errors.Is(err, New(NotFound, "no such thing")) {
+		t.Fatal("expect errors.Is to match an error with the same code and message")
+	}
+	var se *Error
+	if !errors.As(err, &se) || se.Code != NotFound {
+		t.Fatal("expect errors.As to find the status error")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code Code
+	}{
+		{nil, OK},
+		{io.EOF, Unknown},
+		{context.Canceled, Canceled},
+		{fmt.Errorf("call: %w", context.DeadlineExceeded), DeadlineExceeded},
+		{fmt.Errorf("call: %w", New(PermissionDenied, "denied")), PermissionDenied},
+	} {
+		if code := CodeOf(tc.err); code != tc.code {
+			t.Fatalf("%v: expect %s, got %s", tc.err, tc.code, code)
+		}
+	}
+
+	err := fmt.Errorf("rpc server: %w", New(PermissionDenied, "denied"))
+	if se := Convert(err); se.Message != err.Error() {
+		t.Fatalf("expect the message of the outer error, got %q", se.Message)
+	}
+	if se := Wrap(Unavailable, io.EOF); !errors.Is(se, io.EOF) {
+		t.Fatal("expect the wrapped error to be reachable")
+	}
+}
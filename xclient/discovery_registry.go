@@ -0,0 +1,114 @@
+package xclient
+
+import (
+	"log"
+	"time"
+	"vrpc/registry"
+)
+
+// RegistryDiscovery 从 registry 获取服务列表, 列表过期后自动刷新
+type RegistryDiscovery struct {
+	*MultiServersDiscovery
+	registry   string
+	timeout    time.Duration // 服务列表的过期时间
+	lastUpdate time.Time
+	refreshing chan struct{} // 不为 nil 时正在从 registry 获取服务列表, 获取结束后关闭
+	refreshErr error         // 还没有服务列表时, 最近一次获取的错误
+	retryAt    time.Time     // 获取失败后, 在此之前继续使用已有的服务列表而不重试
+}
+
+var _ Discovery = (*RegistryDiscovery)(nil)
+
+const defaultUpdateTimeout = time.Second * 10
+
+// refreshRetryInterval 获取服务列表失败后, 再次尝试前的间隔
+const refreshRetryInterval = time.Second
+
+// NewRegistryDiscovery creates a RegistryDiscovery polling registerAddr,
+// the server list is refreshed if it is older than timeout, 0 means 10s
+func NewRegistryDiscovery(registerAddr string, timeout time.Duration) *RegistryDiscovery {
+	if timeout == 0 {
+		timeout = defaultUpdateTimeout
+	}
+	return &RegistryDiscovery{
+		MultiServersDiscovery: NewMultiServerDiscovery(make([]string, 0)),
+		registry:              registerAddr,
+		timeout:               timeout,
+	}
+}
+
+// Update replaces the server list and marks it fresh
+func (d *RegistryDiscovery) Update(servers []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.servers = servers
+	d.lastUpdate = time.Now()
+	return nil
+}
+
+// Refresh fetches the server list from the registry if it has expired.
+// The registry is queried without holding the lock, giving up after
+// registry.DefaultRequestTimeout. While a fetch is in flight, other callers
+// keep using the expired list, or wait for the fetch if no list has been
+// fetched yet. A failed fetch is only an error if there is no list yet,
+// otherwise the last known servers are kept and the fetch is retried after
+// refreshRetryInterval.
+func (d *RegistryDiscovery) Refresh() error {
+	d.mu.Lock()
+	now := time.Now()
+	if d.lastUpdate.Add(d.timeout).After(now) || d.retryAt.After(now) {
+		d.mu.Unlock()
+		return nil
+	}
+	if ch := d.refreshing; ch != nil {
+		fetched := !d.lastUpdate.IsZero()
+		d.mu.Unlock()
+		if fetched {
+			return nil
+		}
+		<-ch
+		d.mu.RLock()
+		defer d.mu.RUnlock()
+		return d.refreshErr
+	}
+	ch := make(chan struct{})
+	d.refreshing = ch
+	d.mu.Unlock()
+
+	log.Println("rpc registry: refresh servers from registry", d.registry)
+	servers, err := registry.GetServers(d.registry)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.refreshing, d.refreshErr = nil, nil
+	close(ch)
+	if err != nil {
+		log.Println("rpc registry refresh err:", err)
+		if d.lastUpdate.IsZero() {
+			d.refreshErr = err
+			return err
+		}
+		// a registry outage shouldn't fail calls to the servers known so far
+		d.retryAt = time.Now().Add(refreshRetryInterval)
+		return nil
+	}
+	d.servers = servers
+	d.lastUpdate = time.Now()
+	return nil
+}
+
+// Get refreshes the server list if needed and selects a server according to mode
+func (d *RegistryDiscovery) Get(mode SelectMode) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServersDiscovery.Get(mode)
+}
+
+// GetAll refreshes the server list if needed and returns all servers
+func (d *RegistryDiscovery) GetAll() ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAll()
+}
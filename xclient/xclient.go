@@ -0,0 +1,247 @@
+package xclient
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"sync"
+	"vrpc/client"
+	"vrpc/codec"
+)
+
+// XClient 支持负载均衡的客户端, 为每个服务实例缓存一个 *client.Client
+type XClient struct {
+	d        Discovery
+	mode     SelectMode
+	opt      *codec.Option
+	mu       sync.Mutex // protect following
+	clients  map[string]*client.Client
+	dialing  map[string]*dialCall // 正在建立的连接, 每个地址至多一个
+	retry    *client.RetryPolicy
+	breakers *client.Breakers
+}
+
+var _ io.Closer = (*XClient)(nil)
+
+// NewXClient creates a XClient which selects servers from d according to mode
+func NewXClient(d Discovery, mode SelectMode, opt *codec.Option) *XClient {
+	return &XClient{
+		d:       d,
+		mode:    mode,
+		opt:     opt,
+		clients: make(map[string]*client.Client),
+		dialing: make(map[string]*dialCall),
+	}
+}
+
+// Close closes all cached clients
+func (xc *XClient) Close() error {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	for key, c := range xc.clients {
+		// I have no idea how to deal with error, just ignore it.
+		_ = c.Close()
+		delete(xc.clients, key)
+	}
+	return nil
+}
+
+// dialCall is a connection being established, shared by the calls to its server
+type dialCall struct {
+	done chan struct{} // closed once c and err are set
+	c    *client.Client
+	err  error
+}
+
+// dial 返回 rpcAddr 对应的缓存 client, 缓存的 client 不可用时重新建立连接.
+// 建立连接时不持有 xc.mu, 一个迟迟不完成握手的服务实例不会阻塞发往其他实例的调用.
+func (xc *XClient) dial(rpcAddr string) (*client.Client, error) {
+	xc.mu.Lock()
+	c, ok := xc.clients[rpcAddr]
+	if ok && !c.IsAvailable() {
+		_ = c.Close()
+		delete(xc.clients, rpcAddr)
+		c = nil
+	}
+	if c != nil {
+		xc.mu.Unlock()
+		return c, nil
+	}
+	if d, ok := xc.dialing[rpcAddr]; ok {
+		xc.mu.Unlock()
+		<-d.done
+		return d.c, d.err
+	}
+	d := &dialCall{done: make(chan struct{})}
+	xc.dialing[rpcAddr] = d
+	xc.mu.Unlock()
+
+	d.c, d.err = client.XDial(rpcAddr, xc.opt)
+
+	xc.mu.Lock()
+	delete(xc.dialing, rpcAddr)
+	if d.err == nil {
+		if cached := xc.clients[rpcAddr]; cached != nil && cached.IsAvailable() {
+			// another goroutine stored a client first, keep that one
+			_ = d.c.Close()
+			d.c = cached
+		} else {
+			xc.clients[rpcAddr] = d.c
+		}
+	}
+	xc.mu.Unlock()
+	close(d.done)
+	return d.c, d.err
+}
+
+func (xc *XClient) call(ctx context.Context, rpcAddr string, serviceMethod string, args, reply interface{}) error {
+	xc.mu.Lock()
+	breakers := xc.breakers
+	xc.mu.Unlock()
+	var done func(error)
+	if breakers != nil {
+		var err error
+		if done, err = breakers.Get(rpcAddr).Allow(); err != nil {
+			return client.NotSent(err)
+		}
+	}
+
+	c, err := xc.dial(rpcAddr)
+	if err != nil {
+		err = client.NotSent(err)
+	} else {
+		err = c.Call(ctx, serviceMethod, args, reply)
+	}
+	if done != nil {
+		done(err)
+	}
+	return err
+}
+
+// SetBreakerPolicy gives every server a circuit breaker following policy.
+// Call skips servers whose circuit is open, and fails with
+// client.ErrCircuitOpen if every circuit is.
+func (xc *XClient) SetBreakerPolicy(policy client.BreakerPolicy) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.breakers = client.NewBreakers(policy)
+}
+
+// ready reports whether the circuit of rpcAddr lets calls through.
+func (xc *XClient) ready(rpcAddr string) bool {
+	xc.mu.Lock()
+	breakers := xc.breakers
+	xc.mu.Unlock()
+	return breakers == nil || breakers.Get(rpcAddr).Ready()
+}
+
+// SetRetryPolicy makes Call send calls failing with an error retryable by
+// policy again, each time to a server not tried yet if there is one.
+// Broadcast doesn't retry.
+func (xc *XClient) SetRetryPolicy(policy *client.RetryPolicy) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.retry = policy
+}
+
+// Call invokes the named function, waits for it to complete,
+// and returns its error status.
+// xc will choose a proper server.
+func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	xc.mu.Lock()
+	policy := xc.retry
+	xc.mu.Unlock()
+
+	tried := make(map[string]bool)
+	attempt := func(int) error {
+		rpcAddr, err := xc.next(tried)
+		if err != nil {
+			return err
+		}
+		tried[rpcAddr] = true
+		return xc.call(ctx, rpcAddr, serviceMethod, args, reply)
+	}
+	if policy == nil {
+		return attempt(0)
+	}
+	return policy.Do(ctx, serviceMethod, attempt)
+}
+
+// next selects a server according to the mode, preferring servers not in
+// tried and skipping those whose circuit is open.
+func (xc *XClient) next(tried map[string]bool) (string, error) {
+	rpcAddr, err := xc.d.Get(xc.mode)
+	if err != nil || (!tried[rpcAddr] && xc.ready(rpcAddr)) {
+		return rpcAddr, err
+	}
+
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	// round robin moves to the next server by itself, random may need a few draws
+	for i := 0; i < len(servers); i++ {
+		if s, err := xc.d.Get(xc.mode); err == nil && !tried[s] && xc.ready(s) {
+			return s, nil
+		}
+	}
+	for _, s := range servers {
+		if !tried[s] && xc.ready(s) {
+			return s, nil
+		}
+	}
+	// every server was tried already, try one again unless all circuits are open
+	if xc.ready(rpcAddr) {
+		return rpcAddr, nil
+	}
+	for _, s := range servers {
+		if xc.ready(s) {
+			return s, nil
+		}
+	}
+	return "", client.NotSent(client.ErrCircuitOpen)
+}
+
+// Broadcast invokes the named function for every server in discovery at the same time.
+// reply is filled with the first successful answer; once any call fails the
+// others are cancelled and the first error is returned.
+func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return err
+	}
+	if len(servers) == 0 {
+		return ErrNoAvailableServers
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex // protect e and replyDone
+	var e error
+	replyDone := reply == nil // if reply is nil, don't need to set value
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for _, rpcAddr := range servers {
+		wg.Add(1)
+		go func(rpcAddr string) {
+			defer wg.Done()
+			// every call decodes into its own reply, so that they don't race on reply
+			var clonedReply interface{}
+			if reply != nil {
+				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+			}
+			err := xc.call(ctx, rpcAddr, serviceMethod, args, clonedReply)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && e == nil {
+				e = err
+				cancel() // if any call failed, cancel unfinished calls
+			}
+			if err == nil && !replyDone {
+				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(clonedReply).Elem())
+				replyDone = true
+			}
+		}(rpcAddr)
+	}
+	wg.Wait()
+	return e
+}
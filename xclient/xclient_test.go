@@ -0,0 +1,314 @@
+package xclient
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+	"vrpc/client"
+	"vrpc/codec"
+	"vrpc/registry"
+	"vrpc/server"
+)
+
+type Echo string
+
+// Name replies with the name of the server that handled the call
+func (e *Echo) Name(argv int, reply *string) error {
+	*reply = string(*e)
+	return nil
+}
+
+func startServer(t *testing.T, name string) (string, net.Listener) {
+	s := server.NewServer()
+	e := Echo(name)
+	if err := s.Register(&e); err != nil {
+		t.Fatal("register error:", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	go s.Accept(l)
+	return "tcp@" + l.Addr().String(), l
+}
+
+func TestXClient_Call(t *testing.T) {
+	addr1, _ := startServer(t, "a")
+	addr2, _ := startServer(t, "b")
+
+	d := NewMultiServerDiscovery([]string{addr1, addr2})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	seen := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		var reply string
+		if err := xc.Call(context.Background(), "Echo.Name", i, &reply); err != nil {
+			t.Fatal("call error:", err)
+		}
+		seen[reply]++
+	}
+	if seen["a"] != 2 || seen["b"] != 2 {
+		t.Fatalf("expect calls to be spread evenly, got %v", seen)
+	}
+}
+
+func TestXClient_EvictDeadClient(t *testing.T) {
+	addr, _ := startServer(t, "a")
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var reply string
+	if err := xc.Call(context.Background(), "Echo.Name", 0, &reply); err != nil {
+		t.Fatal("call error:", err)
+	}
+	c, _ := xc.dial(addr)
+	_ = c.Close()
+	if err := xc.Call(context.Background(), "Echo.Name", 0, &reply); err != nil {
+		t.Fatal("expect a new connection after the cached one is closed, got", err)
+	}
+}
+
+func TestXClient_SlowDial(t *testing.T) {
+	// a server accepting connections without ever answering the handshake
+	slow, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("network error:", err)
+	}
+	defer func() { _ = slow.Close() }()
+	go func() {
+		for {
+			if _, err := slow.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+	slowAddr := "tcp@" + slow.Addr().String()
+	addr, _ := startServer(t, "a")
+
+	opt := &codec.Option{MagicNumber: codec.MagicNumber, CodecType: codec.GobType, ConnectTimeout: time.Second}
+	xc := NewXClient(NewMultiServerDiscovery([]string{slowAddr, addr}), RoundRobinSelect, opt)
+	defer func() { _ = xc.Close() }()
+
+	dialed := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := xc.dial(slowAddr)
+			dialed <- err
+		}()
+	}
+	time.Sleep(time.Millisecond * 50)
+	start := time.Now()
+	var reply string
+	if err := xc.call(context.Background(), addr, "Echo.Name", 0, &reply); err != nil || reply != "a" {
+		t.Fatalf("expect the call to the healthy server to succeed, got %q (%v)", reply, err)
+	}
+	if d := time.Since(start); d > time.Millisecond*500 {
+		t.Fatal("expect the call not to wait for the slow dial, took", d)
+	}
+	<-dialed
+	<-dialed
+}
+
+func TestMultiServersDiscovery_Get(t *testing.T) {
+	d := NewMultiServerDiscovery(nil)
+	if _, err := d.Get(RandomSelect); err != ErrNoAvailableServers {
+		t.Fatal("expect ErrNoAvailableServers, got", err)
+	}
+	_ = d.Update([]string{"tcp@a", "tcp@b", "tcp@c"})
+	first, _ := d.Get(RoundRobinSelect)
+	second, _ := d.Get(RoundRobinSelect)
+	if first == second {
+		t.Fatal("round robin should not select the same server twice in a row")
+	}
+	all, _ := d.GetAll()
+	if len(all) != 3 {
+		t.Fatal("expect 3 servers, got", all)
+	}
+}
+
+func TestXClient_Broadcast(t *testing.T) {
+	addr1, _ := startServer(t, "a")
+	addr2, _ := startServer(t, "b")
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr1, addr2}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var reply string
+	if err := xc.Broadcast(context.Background(), "Echo.Name", 0, &reply); err != nil {
+		t.Fatal("broadcast error:", err)
+	}
+	if reply != "a" && reply != "b" {
+		t.Fatal("expect reply from one of the servers, got", reply)
+	}
+
+	_ = xc.d.Update([]string{addr1, "tcp@127.0.0.1:1"})
+	if err := xc.Broadcast(context.Background(), "Echo.Name", 0, &reply); err == nil {
+		t.Fatal("expect an error when one of the servers is unreachable")
+	}
+
+	_ = xc.d.Update(nil)
+	if err := xc.Broadcast(context.Background(), "Echo.Name", 0, &reply); err != ErrNoAvailableServers {
+		t.Fatal("expect ErrNoAvailableServers, got", err)
+	}
+}
+
+func TestRegistryDiscovery(t *testing.T) {
+	ts := httptest.NewServer(registry.New(0))
+	defer ts.Close()
+
+	addr, _ := startServer(t, "a")
+	if err := registry.SendHeartbeat(ts.URL, addr); err != nil {
+		t.Fatal("heartbeat error:", err)
+	}
+
+	d := NewRegistryDiscovery(ts.URL, time.Millisecond*50)
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var reply string
+	if err := xc.Call(context.Background(), "Echo.Name", 0, &reply); err != nil || reply != "a" {
+		t.Fatalf("expect reply from a, got %q (%v)", reply, err)
+	}
+
+	addr2, _ := startServer(t, "b")
+	_ = registry.SendHeartbeat(ts.URL, addr2)
+	time.Sleep(time.Millisecond * 60)
+	servers, err := d.GetAll()
+	if err != nil || len(servers) != 2 {
+		t.Fatalf("expect the new server after refresh, got %v (%v)", servers, err)
+	}
+}
+
+func TestRegistryDiscovery_SlowRegistry(t *testing.T) {
+	reg := registry.New(0)
+	entered, release := make(chan struct{}), make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "GET" {
+			close(entered)
+			<-release
+		}
+		reg.ServeHTTP(w, req)
+	}))
+	defer ts.Close()
+	_ = registry.SendHeartbeat(ts.URL, "tcp@127.0.0.1:2")
+
+	d := NewRegistryDiscovery(ts.URL, time.Second)
+	_ = d.Update([]string{"tcp@127.0.0.1:1"})
+	d.mu.Lock()
+	d.lastUpdate = time.Now().Add(-time.Minute) // the list has expired
+	d.mu.Unlock()
+
+	refreshed := make(chan error)
+	go func() { refreshed <- d.Refresh() }()
+	<-entered
+	if s, err := d.Get(RoundRobinSelect); err != nil || s != "tcp@127.0.0.1:1" {
+		t.Fatalf("expect the expired list while the registry is slow, got %q (%v)", s, err)
+	}
+	close(release)
+	if err := <-refreshed; err != nil {
+		t.Fatal("refresh error:", err)
+	}
+	if s, _ := d.Get(RoundRobinSelect); s != "tcp@127.0.0.1:2" {
+		t.Fatalf("expect the fetched list, got %q", s)
+	}
+}
+
+func TestRegistryDiscovery_Outage(t *testing.T) {
+	reg := registry.New(0)
+	var down, fetches int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "GET" {
+			atomic.AddInt32(&fetches, 1)
+			if atomic.LoadInt32(&down) == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		reg.ServeHTTP(w, req)
+	}))
+	defer ts.Close()
+	_ = registry.SendHeartbeat(ts.URL, "tcp@127.0.0.1:1")
+
+	d := NewRegistryDiscovery(ts.URL, time.Millisecond*10)
+	if s, err := d.Get(RandomSelect); err != nil || s != "tcp@127.0.0.1:1" {
+		t.Fatalf("unexpected server %q (%v)", s, err)
+	}
+	atomic.StoreInt32(&down, 1)
+	time.Sleep(time.Millisecond * 20)
+	for i := 0; i < 3; i++ {
+		if s, err := d.Get(RandomSelect); err != nil || s != "tcp@127.0.0.1:1" {
+			t.Fatalf("expect the last known server while the registry is down, got %q (%v)", s, err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Fatal("expect a failed fetch not to be retried right away, got fetches:", n)
+	}
+
+	fresh := NewRegistryDiscovery(ts.URL, 0)
+	if _, err := fresh.Get(RandomSelect); err == nil {
+		t.Fatal("expect an error before the first successful fetch")
+	}
+}
+
+func TestXClient_Retry(t *testing.T) {
+	addr1, l := startServer(t, "a")
+	addr2, _ := startServer(t, "b")
+	_ = l.Close()
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr1, addr2}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetRetryPolicy(&client.RetryPolicy{Backoff: client.Backoff{Initial: time.Millisecond}})
+
+	for i := 0; i < 4; i++ {
+		var reply string
+		if err := xc.Call(context.Background(), "Echo.Name", i, &reply); err != nil || reply != "b" {
+			t.Fatalf("expect the call to move to b, got %q (%v)", reply, err)
+		}
+	}
+}
+
+func TestXClient_CircuitBreaker(t *testing.T) {
+	addr1, l := startServer(t, "a")
+	addr2, _ := startServer(t, "b")
+
+	opened := make(chan string, 1)
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr1, addr2}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetBreakerPolicy(client.BreakerPolicy{
+		ConsecutiveFailures: 1,
+		Cooldown:            time.Minute,
+		OnStateChange: func(addr string, from, to client.BreakerState) {
+			if to == client.StateOpen {
+				opened <- addr
+			}
+		},
+	})
+
+	_ = l.Close()
+	var reply string
+	var failed bool
+	for i := 0; i < 4; i++ {
+		if err := xc.Call(context.Background(), "Echo.Name", i, &reply); err != nil {
+			if failed {
+				t.Fatal("expect a single call to fail before the circuit opens, got", err)
+			}
+			failed = true
+		} else if reply != "b" {
+			t.Fatalf("expect a to be skipped, got %q", reply)
+		}
+	}
+	select {
+	case addr := <-opened:
+		if addr != addr1 {
+			t.Fatal("expect the circuit of a to open, got", addr)
+		}
+	default:
+		t.Fatal("expect a state change callback")
+	}
+}